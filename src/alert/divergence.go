@@ -0,0 +1,244 @@
+package alert
+
+import (
+	"bus"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"tick"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// KindDivergence is the kind of the alerts fired by the divergence monitor
+const KindDivergence = "divergence"
+
+// DivergenceConfig compares the reference source with the mid of the other sources,
+// Threshold is in basis points, Window, MaxAge and Cooldown are in seconds
+type DivergenceConfig struct {
+	Enabled bool `json:"enabled"`
+	Name string `json:"name"`
+	Product string `json:"product"`
+	Reference string `json:"reference"`
+	Sources []string `json:"sources"`
+	Threshold float64 `json:"threshold"`
+	Window int64 `json:"window"`
+	MaxAge int64 `json:"maxAge"`
+	Cooldown int64 `json:"cooldown"`
+	Webhook string `json:"webhook"`
+}
+
+// Validate lists all the problems of the config at once
+func (c DivergenceConfig) Validate() error {
+	var problems []string
+
+	if c.Name == "" {
+		problems = append(problems, "name is required")
+	}
+
+	_, err := tick.FindTable(c.Reference, c.Product)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if len(c.Sources) == 0 {
+		problems = append(problems, "sources are required")
+	}
+
+	for _, source := range c.Sources {
+		if source == c.Reference {
+			problems = append(problems, fmt.Sprintf("source %v is the reference", source))
+			continue
+		}
+
+		_, err := tick.FindTable(source, c.Product)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if c.Threshold <= 0 {
+		problems = append(problems, "threshold should be positive")
+	}
+
+	if c.Window < 0 {
+		problems = append(problems, "window should not be negative")
+	}
+
+	if c.MaxAge < 1 {
+		problems = append(problems, "maxAge should be positive")
+	}
+
+	if c.Cooldown < 0 {
+		problems = append(problems, "cooldown should not be negative")
+	}
+
+	if len(problems) > 0 {
+		return errors.New(fmt.Sprintf("invalid divergence %v: %v", c.Name, problems))
+	}
+
+	return nil
+}
+
+// DivergenceState is the latest comparison, Since is when the divergence started exceeding the threshold
+type DivergenceState struct {
+	Timestamp int64 `json:"timestamp"`
+	Reference float64 `json:"reference"`
+	Mid float64 `json:"mid"`
+	Prices map[string]float64 `json:"prices"`
+	Divergence float64 `json:"divergence"`
+	Threshold float64 `json:"threshold"`
+	Since int64 `json:"since"`
+	Active bool `json:"active"`
+	LastFired int64 `json:"lastFired"`
+}
+
+// DivergenceMonitor compares the latest prices of the sources on every new tick
+type DivergenceMonitor struct {
+	db *sql.DB
+	eventBus *bus.Bus
+	config DivergenceConfig
+
+	mutex sync.Mutex
+	latest map[string]tick.Tick
+	state DivergenceState
+}
+
+func NewDivergenceMonitor(db *sql.DB, eventBus *bus.Bus, config DivergenceConfig) (*DivergenceMonitor, error) {
+	err := config.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &DivergenceMonitor{
+		db: db,
+		eventBus: eventBus,
+		config: config,
+		latest: make(map[string]tick.Tick),
+		state: DivergenceState{Prices: map[string]float64{}, Threshold: config.Threshold},
+	}, nil
+}
+
+// Channels are the bus channels the monitor needs to be subscribed to
+func (m *DivergenceMonitor) Channels() []string {
+	result := []string{bus.Channel(m.config.Reference, m.config.Product)}
+	for _, source := range m.config.Sources {
+		result = append(result, bus.Channel(source, m.config.Product))
+	}
+	return result
+}
+
+func (m *DivergenceMonitor) State() DivergenceState {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	result := m.state
+	result.Prices = make(map[string]float64)
+	for k, v := range m.state.Prices {
+		result.Prices[k] = v
+	}
+
+	return result
+}
+
+// Run compares the sources on the ticks of the subscription until it is closed
+func (m *DivergenceMonitor) Run(subscription *bus.Subscription) {
+	for t := range subscription.C {
+		if t.Product != m.config.Product {
+			continue
+		}
+
+		a := m.evaluate(t)
+		if a != nil {
+			record(m.db, m.eventBus, a, m.config.Webhook)
+		}
+	}
+}
+
+func (m *DivergenceMonitor) evaluate(t bus.Event) *Alert {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if t.Timestamp <= m.latest[t.Source].Timestamp {
+		return nil
+	}
+	m.latest[t.Source] = tick.Tick{Timestamp: t.Timestamp, Price: t.Price}
+
+	c := m.config
+
+	// only the sources fetched within MaxAge of the newest tick are compared
+	now := t.Timestamp
+	for _, v := range m.latest {
+		if v.Timestamp > now {
+			now = v.Timestamp
+		}
+	}
+
+	reference, ok := m.latest[c.Reference]
+	if !ok || now-reference.Timestamp > c.MaxAge {
+		return nil
+	}
+
+	prices := make(map[string]float64)
+	var sum float64
+	for _, source := range c.Sources {
+		v, ok := m.latest[source]
+		if !ok || now-v.Timestamp > c.MaxAge {
+			continue
+		}
+		prices[source] = v.Price
+		sum += v.Price
+	}
+
+	if len(prices) == 0 {
+		return nil
+	}
+
+	mid := sum / float64(len(prices))
+	divergence := (reference.Price - mid) / mid * 10000
+
+	state := &m.state
+	state.Timestamp = now
+	state.Reference = reference.Price
+	state.Mid = mid
+	state.Prices = prices
+	state.Divergence = divergence
+
+	if math.Abs(divergence) <= c.Threshold {
+		if state.Active {
+			log.WithFields(log.Fields{"rule": c.Name, "divergence": divergence}).Info("divergence resolved")
+		}
+		state.Since = 0
+		state.Active = false
+		return nil
+	}
+
+	if state.Since == 0 {
+		state.Since = now
+	}
+
+	if state.Active || now-state.Since < c.Window {
+		return nil
+	}
+	state.Active = true
+
+	message := fmt.Sprintf("%v %v diverged %.2f bps from mid %.2f of %v for %vs, price %v", c.Reference, c.Product, divergence, mid, c.Sources, now-state.Since, reference.Price)
+
+	if state.LastFired > 0 && now-state.LastFired < c.Cooldown {
+		log.WithFields(log.Fields{"rule": c.Name, "message": message}).Info("alert in cooldown")
+		return nil
+	}
+	state.LastFired = now
+
+	return &Alert{
+		Rule: c.Name,
+		Source: c.Reference,
+		Product: c.Product,
+		Kind: KindDivergence,
+		Timestamp: now,
+		Price: reference.Price,
+		Message: fmt.Sprintf("[%v] %v", c.Name, message),
+	}
+}
@@ -0,0 +1,97 @@
+package alert
+
+import (
+	"bus"
+	"math"
+	"strings"
+	"testing"
+	"tick"
+)
+
+var testDivergence = DivergenceConfig{
+	Enabled: true,
+	Name: "divergence",
+	Product: tick.ProductBtcUsd,
+	Reference: tick.SourceBrti,
+	Sources: []string{tick.SourceGdax, tick.SourceBitstamp},
+	Threshold: 50,
+	Window: 10,
+	MaxAge: 30,
+	Cooldown: 100,
+}
+
+func sourceEvent(source string, ts int64, price float64) bus.Event {
+	return bus.Event{Type: bus.TypeTick, Source: source, Product: tick.ProductBtcUsd, Timestamp: ts, Price: price}
+}
+
+func TestDivergenceMonitor(t *testing.T) {
+	m, err := NewDivergenceMonitor(nil, bus.New(), testDivergence)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the mid of gdax and bitstamp is 101, brti at 102 diverges 99 bps
+	steps := []struct {
+		name string
+		event bus.Event
+		fired bool
+		active bool
+		divergence float64
+	}{
+		{"no reference yet", sourceEvent(tick.SourceGdax, 100, 100), false, false, 0},
+		{"no reference yet", sourceEvent(tick.SourceBitstamp, 100, 102), false, false, 0},
+		{"reference at the mid", sourceEvent(tick.SourceBrti, 101, 101), false, false, 0},
+		{"diverged within the window", sourceEvent(tick.SourceBrti, 102, 102), false, false, 10000.0 / 101},
+		{"diverged for the window", sourceEvent(tick.SourceBrti, 112, 102), true, true, 10000.0 / 101},
+		{"fired once", sourceEvent(tick.SourceBrti, 113, 102), false, true, 10000.0 / 101},
+		{"resolved", sourceEvent(tick.SourceBrti, 114, 101), false, false, 0},
+		{"diverged again", sourceEvent(tick.SourceBrti, 115, 102), false, false, 10000.0 / 101},
+		{"in cooldown", sourceEvent(tick.SourceBrti, 125, 102), false, true, 10000.0 / 101},
+		{"still diverged below the mid", sourceEvent(tick.SourceBrti, 126, 100), false, true, -10000.0 / 101},
+		{"sources too old to compare", sourceEvent(tick.SourceBrti, 200, 200), false, true, -10000.0 / 101},
+		{"older tick ignored", sourceEvent(tick.SourceBrti, 150, 101), false, true, -10000.0 / 101},
+	}
+
+	for _, v := range steps {
+		a := m.evaluate(v.event)
+		if (a != nil) != v.fired {
+			t.Errorf("%v: evaluate() = %+v, want fired %v", v.name, a, v.fired)
+		}
+		if a != nil && (a.Kind != KindDivergence || a.Timestamp != v.event.Timestamp || !strings.Contains(a.Message, "diverged 99.01 bps")) {
+			t.Errorf("%v: evaluate() = %+v", v.name, a)
+		}
+
+		state := m.State()
+		if state.Active != v.active || math.Abs(state.Divergence-v.divergence) > 1e-9 {
+			t.Errorf("%v: State() = %+v, want active %v and divergence %v", v.name, state, v.active, v.divergence)
+		}
+	}
+}
+
+func TestDivergenceConfigValidate(t *testing.T) {
+	if err := testDivergence.Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+
+	cases := []struct {
+		name string
+		change func(c *DivergenceConfig)
+		problem string
+	}{
+		{"reference among the sources", func(c *DivergenceConfig) { c.Sources = []string{tick.SourceBrti} }, "source brti is the reference"},
+		{"unknown source", func(c *DivergenceConfig) { c.Sources = []string{"kraken"} }, "unknown source: kraken/btcusd"},
+		{"no threshold", func(c *DivergenceConfig) { c.Threshold = 0 }, "threshold should be positive"},
+		{"no max age", func(c *DivergenceConfig) { c.MaxAge = 0 }, "maxAge should be positive"},
+	}
+
+	for _, v := range cases {
+		config := testDivergence
+		config.Sources = append([]string{}, testDivergence.Sources...)
+		v.change(&config)
+
+		err := config.Validate()
+		if err == nil || !strings.Contains(err.Error(), v.problem) {
+			t.Errorf("%v: Validate() = %v, want %q", v.name, err, v.problem)
+		}
+	}
+}
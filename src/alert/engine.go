@@ -0,0 +1,289 @@
+package alert
+
+import (
+	"bus"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ruleState keeps what is needed to fire a rule only once per crossing or move
+type ruleState struct {
+	rule Rule
+	// side is 1 above the level and -1 below, 0 before the first tick
+	side int
+	// armed is false after a move fired until the price moves back under the percent
+	armed bool
+	lastFired int64
+	ticks []bus.Event
+}
+
+// Engine evaluates the rules on every new tick and records the fired alerts
+type Engine struct {
+	db *sql.DB
+	eventBus *bus.Bus
+
+	mutex sync.Mutex
+	rules map[string]*ruleState
+}
+
+func NewEngine(db *sql.DB, eventBus *bus.Bus) *Engine {
+	return &Engine{db: db, eventBus: eventBus, rules: make(map[string]*ruleState)}
+}
+
+// Load replaces the rules of the origin, the state of the rules not changed is kept
+func (e *Engine) Load(origin string, rules []Rule) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	err := e.check(origin, rules)
+	if err != nil {
+		return err
+	}
+
+	previous := make(map[string]*ruleState)
+	for name, state := range e.rules {
+		if state.rule.Origin == origin {
+			previous[name] = state
+			delete(e.rules, name)
+		}
+	}
+
+	for _, r := range rules {
+		r.Origin = origin
+
+		// an unchanged rule keeps its side and cooldown so reloading does not fire it again
+		if state, ok := previous[r.Name]; ok && reflect.DeepEqual(state.rule, r) {
+			e.rules[r.Name] = state
+			continue
+		}
+
+		e.rules[r.Name] = &ruleState{rule: r, armed: true}
+	}
+
+	return nil
+}
+
+// Check tells whether Load would accept the rules of the origin, without loading them
+func (e *Engine) Check(origin string, rules []Rule) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.check(origin, rules)
+}
+
+func (e *Engine) check(origin string, rules []Rule) error {
+	names := make(map[string]bool)
+	for _, r := range rules {
+		err := r.Validate()
+		if err != nil {
+			return err
+		}
+
+		state, ok := e.rules[r.Name]
+		if names[r.Name] || ok && state.rule.Origin != origin {
+			return errors.New(fmt.Sprintf("duplicated rule: %v", r.Name))
+		}
+		names[r.Name] = true
+	}
+
+	return nil
+}
+
+func (e *Engine) Add(r Rule) error {
+	err := r.Validate()
+	if err != nil {
+		return err
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if state, ok := e.rules[r.Name]; ok && state.rule.Origin != OriginApi {
+		return errors.New(fmt.Sprintf("rule %v is defined in config", r.Name))
+	}
+
+	r.Origin = OriginApi
+
+	err = SaveRule(e.db, r)
+	if err != nil {
+		return err
+	}
+
+	e.rules[r.Name] = &ruleState{rule: r, armed: true}
+
+	return nil
+}
+
+func (e *Engine) Remove(name string) (bool, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	state, ok := e.rules[name]
+	if !ok {
+		return false, nil
+	}
+
+	if state.rule.Origin != OriginApi {
+		return true, errors.New(fmt.Sprintf("rule %v is defined in config", name))
+	}
+
+	err := DeleteRule(e.db, name)
+	if err != nil {
+		return true, err
+	}
+
+	delete(e.rules, name)
+
+	return true, nil
+}
+
+func (e *Engine) Rules() []Rule {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	result := []Rule{}
+	for _, state := range e.rules {
+		result = append(result, state.rule)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+// Run evaluates the ticks of the subscription until it is closed, each tick is only evaluated once
+func (e *Engine) Run(subscription *bus.Subscription) {
+	latest := make(map[string]int64)
+
+	for t := range subscription.C {
+		if t.Timestamp <= latest[t.Channel()] {
+			continue
+		}
+		latest[t.Channel()] = t.Timestamp
+
+		for _, a := range e.evaluate(t) {
+			e.fire(a)
+		}
+	}
+}
+
+func (e *Engine) evaluate(t bus.Event) []*Alert {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	var result []*Alert
+
+	for _, state := range e.rules {
+		r := state.rule
+		if r.Source != t.Source || r.Product != t.Product {
+			continue
+		}
+
+		var message string
+
+		switch r.Kind {
+		case KindAbove, KindBelow:
+			side := 1
+			if t.Price <= r.Level {
+				side = -1
+			}
+
+			crossed := state.side != 0 && side != state.side && (side == 1) == (r.Kind == KindAbove)
+			state.side = side
+
+			if crossed {
+				message = fmt.Sprintf("%v %v crossed %v %v, price %v", r.Source, r.Product, r.Kind, r.Level, t.Price)
+			}
+		case KindMove:
+			state.ticks = append(state.ticks, t)
+
+			evicted := 0
+			for _, v := range state.ticks {
+				if v.Timestamp >= t.Timestamp-r.Window {
+					break
+				}
+				evicted++
+			}
+			state.ticks = state.ticks[evicted:]
+
+			from := state.ticks[0].Price
+			change := (t.Price - from) / from * 100
+
+			if math.Abs(change) < r.Percent {
+				state.armed = true
+			} else if state.armed {
+				state.armed = false
+				message = fmt.Sprintf("%v %v moved %.2f%% in %vs, price %v", r.Source, r.Product, change, r.Window, t.Price)
+			}
+		}
+
+		if message == "" {
+			continue
+		}
+
+		if state.lastFired > 0 && t.Timestamp-state.lastFired < r.Cooldown {
+			log.WithFields(log.Fields{"rule": r.Name, "message": message}).Info("alert in cooldown")
+			continue
+		}
+		state.lastFired = t.Timestamp
+
+		result = append(result, &Alert{
+			Rule: r.Name,
+			Source: r.Source,
+			Product: r.Product,
+			Kind: r.Kind,
+			Timestamp: t.Timestamp,
+			Price: t.Price,
+			Message: fmt.Sprintf("[%v] %v", r.Name, message),
+		})
+	}
+
+	return result
+}
+
+func (e *Engine) webhook(name string) string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if state, ok := e.rules[name]; ok {
+		return state.rule.Webhook
+	}
+	return ""
+}
+
+func (e *Engine) fire(a *Alert) {
+	record(e.db, e.eventBus, a, e.webhook(a.Rule))
+}
+
+// record saves the alert to the history, publishes it to the bus and notifies the webhook if any
+func record(db *sql.DB, eventBus *bus.Bus, a *Alert, webhook string) {
+	log.WithFields(log.Fields{"rule": a.Rule, "source": a.Source, "product": a.Product, "message": a.Message}).Warn("alert fired")
+
+	err := SaveHistory(db, a)
+	if err != nil {
+		log.WithError(err).Error("save alert history error")
+	}
+
+	eventBus.Publish(bus.Event{
+		Type: bus.TypeAlert,
+		Source: a.Source,
+		Product: a.Product,
+		Timestamp: a.Timestamp,
+		Price: a.Price,
+		Message: a.Message,
+		Record: *a,
+	})
+
+	if webhook != "" {
+		go Notify(webhook, a)
+	}
+}
@@ -0,0 +1,90 @@
+package alert
+
+import (
+	"bus"
+	"testing"
+	"tick"
+)
+
+func priceEvent(ts int64, price float64) bus.Event {
+	return bus.Event{Type: bus.TypeTick, Source: tick.SourceBrti, Product: tick.ProductBtcUsd, Timestamp: ts, Price: price}
+}
+
+func aboveRule(level float64) Rule {
+	return Rule{Name: "brti above", Source: tick.SourceBrti, Product: tick.ProductBtcUsd, Kind: KindAbove, Level: level}
+}
+
+func TestLoadKeepsStateOfUnchangedRules(t *testing.T) {
+	e := NewEngine(nil, bus.New())
+
+	err := e.Load(OriginConfig, []Rule{aboveRule(100)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.evaluate(priceEvent(1, 90))
+
+	// reloading the same rule keeps the side below the level, so the next tick above fires
+	err = e.Load(OriginConfig, []Rule{aboveRule(100)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if alerts := e.evaluate(priceEvent(2, 110)); len(alerts) != 1 {
+		t.Errorf("%v alerts fired after reloading an unchanged rule, want 1", len(alerts))
+	}
+}
+
+func TestLoadResetsStateOfChangedRules(t *testing.T) {
+	e := NewEngine(nil, bus.New())
+
+	err := e.Load(OriginConfig, []Rule{aboveRule(100)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.evaluate(priceEvent(1, 90))
+
+	// the changed rule starts over, the first tick only tells which side the price is on
+	err = e.Load(OriginConfig, []Rule{aboveRule(105)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if alerts := e.evaluate(priceEvent(2, 110)); len(alerts) != 0 {
+		t.Errorf("%v alerts fired on the first tick of a changed rule, want 0", len(alerts))
+	}
+
+	if rules := e.Rules(); len(rules) != 1 || rules[0].Level != 105 {
+		t.Errorf("Rules() = %v, want the changed rule", rules)
+	}
+}
+
+func TestLoadRejectsDuplicatedRules(t *testing.T) {
+	e := NewEngine(nil, bus.New())
+
+	err := e.Load(OriginConfig, []Rule{aboveRule(100)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = e.Load(OriginConfig, []Rule{aboveRule(100), aboveRule(110)})
+	if err == nil {
+		t.Error("Load() should reject rules of the same name")
+	}
+
+	err = e.Load(OriginApi, []Rule{aboveRule(120)})
+	if err == nil {
+		t.Error("Load() should reject a rule defined by another origin")
+	}
+
+	err = e.Load(OriginConfig, []Rule{{Name: "invalid", Source: tick.SourceBrti, Product: tick.ProductBtcUsd, Kind: KindAbove}})
+	if err == nil {
+		t.Error("Load() should reject an invalid rule")
+	}
+
+	// the rejected loads leave the rules as they were
+	if rules := e.Rules(); len(rules) != 1 || rules[0].Level != 100 {
+		t.Errorf("Rules() = %v, want the first loaded rule", rules)
+	}
+}
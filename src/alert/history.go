@@ -0,0 +1,75 @@
+package alert
+
+import (
+	"database/sql"
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Alert is a fired rule, Timestamp and Price are of the tick which fired it
+type Alert struct {
+	Id int64 `json:"id"`
+	Rule string `json:"rule"`
+	Source string `json:"source"`
+	Product string `json:"product"`
+	Kind string `json:"kind"`
+	Timestamp int64 `json:"timestamp"`
+	Price float64 `json:"price"`
+	Message string `json:"message"`
+}
+
+func SaveHistory(db *sql.DB, a *Alert) error {
+	saveSql := "INSERT INTO `alert_history`(`rule_name`,`source`,`product`,`kind`,`log_time`,`log_price`,`message`) VALUES(?,?,?,?,?,?,?)"
+	stmt, err := db.Prepare(saveSql)
+	if err != nil {
+		log.WithError(err).Error("prepare stmt error")
+		return err
+	}
+
+	defer stmt.Close()
+
+	res, err := stmt.Exec(a.Rule, a.Source, a.Product, a.Kind, a.Timestamp, a.Price, a.Message)
+	if err != nil {
+		log.WithError(err).Error("exec save sql error")
+		return err
+	}
+
+	a.Id, err = res.LastInsertId()
+	if err != nil {
+		log.WithError(err).Error("read insert id error")
+		return err
+	}
+
+	return nil
+}
+
+func FindHistory(db *sql.DB, count int32) ([]Alert, error) {
+	if count < 1 || count > 1000 {
+		log.WithField("count", count).Warn("query count out of range")
+		return nil, errors.New("query count out of range")
+	}
+
+	rows, err := db.Query("SELECT `id`,`rule_name`,`source`,`product`,`kind`,`log_time`,`log_price`,`message` FROM `alert_history` ORDER BY `id` DESC LIMIT ?", count)
+	if err != nil {
+		log.WithError(err).Error("query alert history error")
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	result := []Alert{}
+	for rows.Next() {
+		var a Alert
+
+		err = rows.Scan(&a.Id, &a.Rule, &a.Source, &a.Product, &a.Kind, &a.Timestamp, &a.Price, &a.Message)
+		if err != nil {
+			log.WithError(err).Error("read alert history error")
+			return nil, err
+		}
+
+		result = append(result, a)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,145 @@
+package alert
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"tick"
+	"util"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// KindAbove fires when the price crosses above the level
+	KindAbove = "above"
+	// KindBelow fires when the price crosses below the level
+	KindBelow = "below"
+	// KindMove fires when the price moves more than the percent within the window
+	KindMove = "move"
+)
+
+const (
+	OriginConfig = "config"
+	OriginApi = "api"
+)
+
+// Rule is evaluated on every new tick of its source, Window and Cooldown are in seconds
+type Rule struct {
+	Name string `json:"name"`
+	Source string `json:"source"`
+	Product string `json:"product"`
+	Kind string `json:"kind"`
+	Level float64 `json:"level,omitempty"`
+	Percent float64 `json:"percent,omitempty"`
+	Window int64 `json:"window,omitempty"`
+	Cooldown int64 `json:"cooldown"`
+	Webhook string `json:"webhook"`
+	Origin string `json:"origin"`
+}
+
+// Validate lists all the problems of the rule at once
+func (r Rule) Validate() error {
+	var problems []string
+
+	if r.Name == "" {
+		problems = append(problems, "name is required")
+	}
+
+	_, err := tick.FindTable(r.Source, r.Product)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	switch r.Kind {
+	case KindAbove, KindBelow:
+		if r.Level <= 0 {
+			problems = append(problems, "level should be positive")
+		}
+	case KindMove:
+		if r.Percent <= 0 {
+			problems = append(problems, "percent should be positive")
+		}
+		if r.Window < 1 {
+			problems = append(problems, "window should be positive")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown kind: %v", r.Kind))
+	}
+
+	if r.Cooldown < 0 {
+		problems = append(problems, "cooldown should not be negative")
+	}
+
+	if len(problems) > 0 {
+		return errors.New(fmt.Sprintf("invalid rule %v: %v", r.Name, problems))
+	}
+
+	return nil
+}
+
+func FindRules(db *sql.DB) ([]Rule, error) {
+	rows, err := db.Query("SELECT `rule_name`,`source`,`product`,`kind`,`level`,`percent`,`window`,`cooldown`,`webhook` FROM `alert_rules` ORDER BY `rule_name` ASC")
+	if err != nil {
+		log.WithError(err).Error("query alert rules error")
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var result []Rule
+	for rows.Next() {
+		r := Rule{Origin: OriginApi}
+
+		err = rows.Scan(&r.Name, &r.Source, &r.Product, &r.Kind, &r.Level, &r.Percent, &r.Window, &r.Cooldown, &r.Webhook)
+		if err != nil {
+			log.WithError(err).Error("read alert rules error")
+			return nil, err
+		}
+
+		result = append(result, r)
+	}
+
+	return result, nil
+}
+
+func SaveRule(db *sql.DB, r Rule) error {
+	saveSql := "INSERT OR REPLACE INTO `alert_rules`(`rule_name`,`source`,`product`,`kind`,`level`,`percent`,`window`,`cooldown`,`webhook`) VALUES(?,?,?,?,?,?,?,?,?)"
+	stmt, err := db.Prepare(saveSql)
+	if err != nil {
+		log.WithError(err).Error("prepare stmt error")
+		return err
+	}
+
+	defer stmt.Close()
+
+	_, err = stmt.Exec(r.Name, r.Source, r.Product, r.Kind, r.Level, r.Percent, r.Window, r.Cooldown, r.Webhook)
+	if err != nil {
+		log.WithError(err).Error("exec save sql error")
+		return err
+	}
+
+	return nil
+}
+
+func DeleteRule(db *sql.DB, name string) error {
+	_, err := db.Exec("DELETE FROM `alert_rules` WHERE `rule_name`=?", name)
+	if err != nil {
+		log.WithError(err).Error("exec delete sql error")
+		return err
+	}
+
+	return nil
+}
+
+func InitDb(db *sql.DB) {
+	util.CheckAndCreateTable(db,
+		"alert_rules",
+		"CREATE TABLE `alert_rules` (`rule_name` VARCHAR(64) PRIMARY KEY,`source` VARCHAR(32) NOT NULL,`product` VARCHAR(32) NOT NULL,`kind` VARCHAR(16) NOT NULL,`level` DECIMAL(10,2) NOT NULL DEFAULT 0,`percent` DECIMAL(10,4) NOT NULL DEFAULT 0,`window` INTEGER NOT NULL DEFAULT 0,`cooldown` INTEGER NOT NULL DEFAULT 0,`webhook` VARCHAR(512) NOT NULL DEFAULT '',`created_time` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)")
+
+	util.CheckAndCreateTable(db,
+		"alert_history",
+		"CREATE TABLE `alert_history` (`id` INTEGER PRIMARY KEY AUTOINCREMENT,`rule_name` VARCHAR(64) NOT NULL,`source` VARCHAR(32) NOT NULL,`product` VARCHAR(32) NOT NULL,`kind` VARCHAR(16) NOT NULL,`log_time` BIGINT NOT NULL,`log_price` DECIMAL(10,2) NOT NULL,`message` TEXT NOT NULL,`created_time` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)")
+
+	util.ExecuteStmtSql(db, "CREATE INDEX IF NOT EXISTS idx_alert_history_time ON `alert_history`(`log_time`)")
+}
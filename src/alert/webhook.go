@@ -0,0 +1,45 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// slackPayload is the payload of Slack incoming webhooks, the alert is attached for other receivers
+type slackPayload struct {
+	Text string `json:"text"`
+	Alert *Alert `json:"alert"`
+}
+
+// Notify posts the alert to the webhook
+func Notify(webhook string, a *Alert) error {
+	body, err := json.Marshal(slackPayload{a.Message, a})
+	if err != nil {
+		return err
+	}
+
+	httpClient := http.Client{
+		Timeout: time.Second * 5,
+	}
+
+	res, err := httpClient.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.WithError(err).Error("request error")
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		log.WithField("status", res.StatusCode).Error("post webhook error")
+		return errors.New(fmt.Sprintf("webhook responded %v", res.StatusCode))
+	}
+
+	return nil
+}
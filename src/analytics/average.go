@@ -0,0 +1,185 @@
+package analytics
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"gdax"
+	"strings"
+	"tick"
+)
+
+var ErrNoData = errors.New("no data in range")
+
+// Average is a VWAP or TWAP of a single source, Volume is only set for VWAP
+type Average struct {
+	Source string `json:"source"`
+	Product string `json:"product"`
+	Start int64 `json:"start"`
+	End int64 `json:"end"`
+	Price float64 `json:"price"`
+	Volume float64 `json:"volume,omitempty"`
+	Count int64 `json:"count"`
+}
+
+type Composite struct {
+	Start int64 `json:"start"`
+	End int64 `json:"end"`
+	Price float64 `json:"price"`
+	Volume float64 `json:"volume,omitempty"`
+	Sources []Average `json:"sources"`
+}
+
+// HasVolume tells whether the source stores volume, only the GDAX candles do: the BRTI is an index without trades
+// and the Bitstamp ticker only reports the volume of the last hour, not the one traded at its price
+func HasVolume(table tick.Table) bool {
+	return table.Source == tick.SourceGdax && table.Product == tick.ProductBtcUsd
+}
+
+// CheckVolume tells the sources without volume, their VWAP cannot be computed
+func CheckVolume(tables []tick.Table) error {
+	var missing []string
+	for _, v := range tables {
+		if !HasVolume(v) {
+			missing = append(missing, fmt.Sprintf("%v %v", v.Source, v.Product))
+		}
+	}
+
+	if len(missing) > 0 {
+		return errors.New(fmt.Sprintf("volume not available for %v, only the gdax candles have volume", strings.Join(missing, ", ")))
+	}
+
+	return nil
+}
+
+func VWAP(db *sql.DB, table tick.Table, tsStart int64, tsEnd int64) (Average, error) {
+	result := Average{Source: table.Source, Product: table.Product, Start: tsStart, End: tsEnd}
+
+	err := CheckVolume([]tick.Table{table})
+	if err != nil {
+		return result, err
+	}
+
+	vwap, err := gdax.FindVWAP(db, tsStart, tsEnd)
+	if err != nil {
+		return result, err
+	}
+
+	if vwap.Count == 0 {
+		return result, ErrNoData
+	}
+
+	result.Price = vwap.Price
+	result.Volume = vwap.Volume
+	result.Count = vwap.Count
+
+	return result, nil
+}
+
+// TWAP weights each tick by the time until the next tick, the tick before tsStart is used for the beginning of the range
+func TWAP(db *sql.DB, table tick.Table, tsStart int64, tsEnd int64) (Average, error) {
+	result := Average{Source: table.Source, Product: table.Product, Start: tsStart, End: tsEnd}
+
+	var prev *tick.Tick
+	var from int64
+
+	seed, err := tick.FindAsOf(db, table, tsStart, tick.ModeBefore, tick.MaxTolerance)
+	if err == nil {
+		prev = &tick.Tick{Timestamp: tsStart, Price: seed.Price}
+		from = tsStart
+	} else if err != tick.ErrNotFound {
+		return result, err
+	}
+
+	var weighted float64
+
+	err = tick.Each(db, table, tsStart, tsEnd, func(t tick.Tick) error {
+		if prev == nil {
+			from = t.Timestamp
+		} else {
+			weighted += prev.Price * float64(t.Timestamp-prev.Timestamp)
+		}
+
+		prev = &tick.Tick{Timestamp: t.Timestamp, Price: t.Price}
+		result.Count++
+
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	if prev == nil {
+		return result, ErrNoData
+	}
+
+	weighted += prev.Price * float64(tsEnd-prev.Timestamp)
+
+	if tsEnd == from {
+		result.Price = prev.Price
+	} else {
+		result.Price = weighted / float64(tsEnd-from)
+	}
+
+	return result, nil
+}
+
+// CompositeVWAP weights the VWAP of each source by its volume, sources without data are skipped, all the sources should have volume
+func CompositeVWAP(db *sql.DB, tables []tick.Table, tsStart int64, tsEnd int64) (Composite, error) {
+	result := Composite{Start: tsStart, End: tsEnd, Sources: []Average{}}
+
+	err := CheckVolume(tables)
+	if err != nil {
+		return result, err
+	}
+
+	var amount float64
+	for _, table := range tables {
+		v, err := VWAP(db, table, tsStart, tsEnd)
+		if err == ErrNoData {
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+
+		amount += v.Price * v.Volume
+		result.Volume += v.Volume
+		result.Sources = append(result.Sources, v)
+	}
+
+	if result.Volume == 0 {
+		return result, ErrNoData
+	}
+
+	result.Price = amount / result.Volume
+
+	return result, nil
+}
+
+// CompositeTWAP is the equally weighted mean of the TWAP of each source, sources without data are skipped
+func CompositeTWAP(db *sql.DB, tables []tick.Table, tsStart int64, tsEnd int64) (Composite, error) {
+	result := Composite{Start: tsStart, End: tsEnd, Sources: []Average{}}
+
+	var sum float64
+	for _, table := range tables {
+		v, err := TWAP(db, table, tsStart, tsEnd)
+		if err == ErrNoData {
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+
+		sum += v.Price
+		result.Sources = append(result.Sources, v)
+	}
+
+	if len(result.Sources) == 0 {
+		return result, ErrNoData
+	}
+
+	result.Price = sum / float64(len(result.Sources))
+
+	return result, nil
+}
@@ -0,0 +1,136 @@
+package analytics
+
+import (
+	"brti"
+	"database/sql"
+	"gdax"
+	"math"
+	"path/filepath"
+	"testing"
+	"tick"
+	"util"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	db, err := util.OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	brti.InitDb(db)
+	gdax.InitDb(db)
+
+	return db
+}
+
+func saveTicks(t *testing.T, db *sql.DB, ticks ...tick.Tick) {
+	for _, v := range ticks {
+		_, err := db.Exec("INSERT INTO `brti_logs`(`log_time`,`log_price`) VALUES(?,?)", v.Timestamp, v.Price)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func brtiTable(t *testing.T) tick.Table {
+	table, err := tick.FindTable(tick.SourceBrti, tick.ProductBtcUsd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+func almostEqual(a float64, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestTWAP(t *testing.T) {
+	db := openTestDB(t)
+	saveTicks(t, db, tick.Tick{Timestamp: 100, Price: 10}, tick.Tick{Timestamp: 110, Price: 20}, tick.Tick{Timestamp: 130, Price: 30})
+
+	cases := []struct {
+		name string
+		start int64
+		end int64
+		price float64
+		count int64
+		err error
+	}{
+		{"ticks weighted until the next one", 100, 130, 500.0 / 30, 3, nil},
+		{"the tick before the start opens the range", 105, 130, 18, 2, nil},
+		{"no tick before the start", 90, 120, 15, 2, nil},
+		{"single timestamp", 130, 130, 30, 1, nil},
+		{"range after the last tick", 200, 300, 30, 0, nil},
+		{"no data", 0, 50, 0, 0, ErrNoData},
+	}
+
+	for _, v := range cases {
+		average, err := TWAP(db, brtiTable(t), v.start, v.end)
+		if err != v.err {
+			t.Errorf("%v: TWAP() error = %v, want %v", v.name, err, v.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if !almostEqual(average.Price, v.price) || average.Count != v.count {
+			t.Errorf("%v: TWAP() = %v with %v ticks, want %v with %v ticks", v.name, average.Price, average.Count, v.price, v.count)
+		}
+	}
+}
+
+func TestVWAP(t *testing.T) {
+	db := openTestDB(t)
+
+	for _, v := range []gdax.Historic{
+		{Time: 60, Low: 6, High: 12, Open: 8, Close: 9, Volume: 1},
+		{Time: 120, Low: 15, High: 30, Open: 20, Close: 15, Volume: 3},
+		{Time: 180, Low: 100, High: 100, Open: 100, Close: 100, Volume: 0},
+	} {
+		row, err := gdax.HistoricRow(&v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(row.Sql, row.Args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	table, _ := tick.FindTable(tick.SourceGdax, tick.ProductBtcUsd)
+
+	cases := []struct {
+		name string
+		start int64
+		end int64
+		price float64
+		volume float64
+		count int64
+		err error
+	}{
+		{"typical prices weighted by volume", 60, 180, 17.25, 4, 2, nil},
+		{"single candle", 100, 150, 20, 3, 1, nil},
+		{"candles without volume are skipped", 150, 200, 0, 0, 0, ErrNoData},
+	}
+
+	for _, v := range cases {
+		average, err := VWAP(db, table, v.start, v.end)
+		if err != v.err {
+			t.Errorf("%v: VWAP() error = %v, want %v", v.name, err, v.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if !almostEqual(average.Price, v.price) || average.Volume != v.volume || average.Count != v.count {
+			t.Errorf("%v: VWAP() = %+v, want price %v, volume %v and %v candles", v.name, average, v.price, v.volume, v.count)
+		}
+	}
+
+	if _, err := CompositeVWAP(db, []tick.Table{table, brtiTable(t)}, 60, 180); err == nil {
+		t.Error("CompositeVWAP() should reject a source without volume")
+	}
+}
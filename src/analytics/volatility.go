@@ -0,0 +1,323 @@
+package analytics
+
+import (
+	"candle"
+	"database/sql"
+	"errors"
+	"math"
+	"sync"
+	"tick"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MaxReturns is the max number of returns a single query could return
+const MaxReturns = 5000
+
+const secondsPerYear = 365 * 86400
+
+type Return struct {
+	Time int64 `json:"time"`
+	Price float64 `json:"price"`
+	LogReturn float64 `json:"log_return"`
+}
+
+type Volatility struct {
+	Source string `json:"source"`
+	Product string `json:"product"`
+	Start int64 `json:"start"`
+	End int64 `json:"end"`
+	Sampling int64 `json:"sampling"`
+	Samples int64 `json:"samples"`
+	Volatility float64 `json:"volatility"`
+	Annualized float64 `json:"annualized"`
+}
+
+type Drawdown struct {
+	Source string `json:"source"`
+	Product string `json:"product"`
+	Start int64 `json:"start"`
+	End int64 `json:"end"`
+	Peak float64 `json:"peak"`
+	PeakTime int64 `json:"peak_time"`
+	Trough float64 `json:"trough"`
+	TroughTime int64 `json:"trough_time"`
+	MaxDrawdown float64 `json:"max_drawdown"`
+}
+
+// CheckReturnsRange makes sure the range does not contain more than MaxReturns samples
+func CheckReturnsRange(tsStart int64, tsEnd int64, sampling int64) error {
+	if sampling < 1 {
+		return errors.New("invalid sampling")
+	}
+	if tsEnd < tsStart {
+		return errors.New("invalid time range")
+	}
+	if (tsEnd-tsStart)/sampling+1 > MaxReturns {
+		return errors.New("too many samples in range")
+	}
+	return nil
+}
+
+// sample walks through the last price of each sampling bucket, the bucket in progress at tsEnd is included
+func sample(db *sql.DB, table tick.Table, tsStart int64, tsEnd int64, sampling int64, fn func(bucket int64, price float64)) error {
+	var bucket int64
+	var price float64
+	started := false
+
+	err := tick.Each(db, table, tsStart, tsEnd, func(t tick.Tick) error {
+		b := candle.BucketStart(t.Timestamp, sampling)
+		if started && b != bucket {
+			fn(bucket, price)
+		}
+
+		bucket = b
+		price = t.Price
+		started = true
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if started {
+		fn(bucket, price)
+	}
+
+	return nil
+}
+
+// Returns computes the log returns between the sampled prices
+func Returns(db *sql.DB, table tick.Table, tsStart int64, tsEnd int64, sampling int64) ([]Return, error) {
+	err := CheckReturnsRange(tsStart, tsEnd, sampling)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Return{}
+	var prev float64
+
+	err = sample(db, table, tsStart, tsEnd, sampling, func(bucket int64, price float64) {
+		if prev > 0 && price > 0 {
+			result = append(result, Return{bucket, price, math.Log(price / prev)})
+		}
+		prev = price
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// RealizedVolatility is the standard deviation of the sampled log returns, annualized by the sampling
+func RealizedVolatility(db *sql.DB, table tick.Table, tsStart int64, tsEnd int64, sampling int64) (Volatility, error) {
+	result := Volatility{Source: table.Source, Product: table.Product, Start: tsStart, End: tsEnd, Sampling: sampling}
+
+	returns, err := Returns(db, table, tsStart, tsEnd, sampling)
+	if err != nil {
+		return result, err
+	}
+
+	if len(returns) < 2 {
+		return result, ErrNoData
+	}
+
+	var sum float64
+	var sumSquare float64
+	for _, v := range returns {
+		sum += v.LogReturn
+		sumSquare += v.LogReturn * v.LogReturn
+	}
+
+	result.Samples = int64(len(returns))
+	result.Volatility = sampleStdDev(sum, sumSquare, result.Samples)
+	result.Annualized = result.Volatility * math.Sqrt(float64(secondsPerYear)/float64(sampling))
+
+	return result, nil
+}
+
+func sampleStdDev(sum float64, sumSquare float64, n int64) float64 {
+	if n < 2 {
+		return 0
+	}
+	variance := (sumSquare - sum*sum/float64(n)) / float64(n-1)
+	if variance < 0 {
+		return 0
+	}
+	return math.Sqrt(variance)
+}
+
+// FindDrawdown finds the max peak to trough decline of the ticks in range, MaxDrawdown is the fraction of the peak
+func FindDrawdown(db *sql.DB, table tick.Table, tsStart int64, tsEnd int64) (Drawdown, error) {
+	result := Drawdown{Source: table.Source, Product: table.Product, Start: tsStart, End: tsEnd}
+
+	var peak float64
+	var peakTime int64
+	var count int64
+
+	err := tick.Each(db, table, tsStart, tsEnd, func(t tick.Tick) error {
+		count++
+
+		if t.Price > peak {
+			peak = t.Price
+			peakTime = t.Timestamp
+		}
+
+		if count == 1 || peak > 0 && (peak-t.Price)/peak > result.MaxDrawdown {
+			result.Peak = peak
+			result.PeakTime = peakTime
+			result.Trough = t.Price
+			result.TroughTime = t.Timestamp
+			result.MaxDrawdown = (peak - t.Price) / peak
+		}
+
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	if count == 0 {
+		return result, ErrNoData
+	}
+
+	return result, nil
+}
+
+// VolatilityTracker keeps the rolling realized volatility up to date, each Update only reads the ticks saved since the last one
+// unless one of them is older than the last tick read, the window is recomputed then
+type VolatilityTracker struct {
+	table tick.Table
+	window int64
+	sampling int64
+
+	mutex sync.RWMutex
+	rowid int64
+	lastTimestamp int64
+	bucket int64
+	price float64
+	prevPrice float64
+	returns []Return
+	sum float64
+	sumSquare float64
+	updatedTime int64
+}
+
+func NewVolatilityTracker(table tick.Table, window int64, sampling int64) *VolatilityTracker {
+	return &VolatilityTracker{table: table, window: window, sampling: sampling}
+}
+
+func (t *VolatilityTracker) Update(db *sql.DB) error {
+	return t.update(db, time.Now().Unix())
+}
+
+func (t *VolatilityTracker) update(db *sql.DB, now int64) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	entry := log.WithFields(log.Fields{"source": t.table.Source, "product": t.table.Product})
+
+	// the ticks are followed by their insertion order, a cursor on the timestamps would miss the ticks saved late
+	inserted, err := tick.FindInserted(db, t.table, t.rowid)
+	if err != nil {
+		entry.WithError(err).Error("update volatility error")
+		return err
+	}
+
+	if inserted.Count > 0 {
+		tsStart := t.lastTimestamp + 1
+		if t.lastTimestamp == 0 || inserted.Earliest <= t.lastTimestamp {
+			if t.lastTimestamp > 0 {
+				entry.WithField("timestamp", inserted.Earliest).Info("late tick saved, recompute the volatility window")
+			}
+
+			// only the current window is needed
+			t.reset()
+			tsStart = candle.BucketStart(now-t.window, t.sampling) - t.sampling
+		}
+
+		// the ticks ahead of the clock are read now since their rowids are already passed
+		tsEnd := now
+		if inserted.Latest > tsEnd {
+			tsEnd = inserted.Latest
+		}
+
+		err = tick.Each(db, t.table, tsStart, tsEnd, func(v tick.Tick) error {
+			b := candle.BucketStart(v.Timestamp, t.sampling)
+			if t.price > 0 && b != t.bucket {
+				t.closeBucket()
+			}
+
+			t.bucket = b
+			t.price = v.Price
+			t.lastTimestamp = v.Timestamp
+
+			return nil
+		})
+		if err != nil {
+			entry.WithError(err).Error("update volatility error")
+			return err
+		}
+
+		t.rowid = inserted.Rowid
+	}
+
+	// drop the returns out of the window
+	evicted := 0
+	for _, v := range t.returns {
+		if v.Time > now-t.window {
+			break
+		}
+		t.sum -= v.LogReturn
+		t.sumSquare -= v.LogReturn * v.LogReturn
+		evicted++
+	}
+	t.returns = t.returns[evicted:]
+
+	t.updatedTime = now
+
+	return nil
+}
+
+// reset forgets the ticks read, keeping the rowid read up to
+func (t *VolatilityTracker) reset() {
+	t.lastTimestamp = 0
+	t.bucket = 0
+	t.price = 0
+	t.prevPrice = 0
+	t.returns = nil
+	t.sum = 0
+	t.sumSquare = 0
+}
+
+func (t *VolatilityTracker) closeBucket() {
+	if t.prevPrice > 0 {
+		r := math.Log(t.price / t.prevPrice)
+		t.returns = append(t.returns, Return{t.bucket, t.price, r})
+		t.sum += r
+		t.sumSquare += r * r
+	}
+	t.prevPrice = t.price
+}
+
+func (t *VolatilityTracker) Snapshot() Volatility {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	result := Volatility{
+		Source: t.table.Source,
+		Product: t.table.Product,
+		Start: t.updatedTime - t.window,
+		End: t.updatedTime,
+		Sampling: t.sampling,
+		Samples: int64(len(t.returns)),
+	}
+
+	result.Volatility = sampleStdDev(t.sum, t.sumSquare, result.Samples)
+	result.Annualized = result.Volatility * math.Sqrt(float64(secondsPerYear)/float64(t.sampling))
+
+	return result
+}
@@ -0,0 +1,176 @@
+package analytics
+
+import (
+	"math"
+	"testing"
+	"tick"
+)
+
+func TestReturnsAndVolatility(t *testing.T) {
+	db := openTestDB(t)
+
+	// the last price of each minute is sampled, the 3rd minute has no tick
+	saveTicks(t, db,
+		tick.Tick{Timestamp: 6000, Price: 100},
+		tick.Tick{Timestamp: 6030, Price: 105},
+		tick.Tick{Timestamp: 6060, Price: 110},
+		tick.Tick{Timestamp: 6210, Price: 99},
+		tick.Tick{Timestamp: 6250, Price: 121})
+
+	want := []Return{
+		{6060, 110, math.Log(110.0 / 105)},
+		{6180, 99, math.Log(99.0 / 110)},
+		{6240, 121, math.Log(121.0 / 99)},
+	}
+
+	returns, err := Returns(db, brtiTable(t), 6000, 6300, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(returns) != len(want) {
+		t.Fatalf("Returns() = %+v, want %+v", returns, want)
+	}
+	for i, v := range want {
+		if returns[i].Time != v.Time || returns[i].Price != v.Price || !almostEqual(returns[i].LogReturn, v.LogReturn) {
+			t.Errorf("return %v = %+v, want %+v", i, returns[i], v)
+		}
+	}
+
+	volatility, err := RealizedVolatility(db, brtiTable(t), 6000, 6300, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mean float64
+	for _, v := range want {
+		mean += v.LogReturn / 3
+	}
+	var squares float64
+	for _, v := range want {
+		squares += math.Pow(v.LogReturn-mean, 2)
+	}
+	stdDev := math.Sqrt(squares / 2)
+	if volatility.Samples != 3 || !almostEqual(volatility.Volatility, stdDev) || !almostEqual(volatility.Annualized, stdDev*math.Sqrt(secondsPerYear/60.0)) {
+		t.Errorf("RealizedVolatility() = %+v, want the sample standard deviation %v of 3 returns", volatility, stdDev)
+	}
+
+	if _, err = RealizedVolatility(db, brtiTable(t), 6000, 6100, 60); err != ErrNoData {
+		t.Errorf("RealizedVolatility() error = %v, want %v with a single return", err, ErrNoData)
+	}
+}
+
+func TestCheckReturnsRange(t *testing.T) {
+	cases := []struct {
+		name string
+		start int64
+		end int64
+		sampling int64
+		valid bool
+	}{
+		{"max samples", 0, (MaxReturns - 1) * 60, 60, true},
+		{"too many samples", 0, MaxReturns * 60, 60, false},
+		{"no sampling", 0, 60, 0, false},
+		{"reversed range", 60, 0, 60, false},
+	}
+
+	for _, v := range cases {
+		if err := CheckReturnsRange(v.start, v.end, v.sampling); (err == nil) != v.valid {
+			t.Errorf("%v: CheckReturnsRange() = %v, want valid %v", v.name, err, v.valid)
+		}
+	}
+}
+
+func TestFindDrawdown(t *testing.T) {
+	db := openTestDB(t)
+	saveTicks(t, db,
+		tick.Tick{Timestamp: 1, Price: 100},
+		tick.Tick{Timestamp: 2, Price: 120},
+		tick.Tick{Timestamp: 3, Price: 90},
+		tick.Tick{Timestamp: 4, Price: 130},
+		tick.Tick{Timestamp: 5, Price: 110})
+
+	cases := []struct {
+		name string
+		start int64
+		end int64
+		want Drawdown
+		err error
+	}{
+		{"deepest decline from the peak before it", 1, 5, Drawdown{Peak: 120, PeakTime: 2, Trough: 90, TroughTime: 3, MaxDrawdown: 0.25}, nil},
+		{"decline from a later peak", 4, 5, Drawdown{Peak: 130, PeakTime: 4, Trough: 110, TroughTime: 5, MaxDrawdown: 20.0 / 130}, nil},
+		{"rising prices", 1, 2, Drawdown{Peak: 100, PeakTime: 1, Trough: 100, TroughTime: 1}, nil},
+		{"no data", 10, 20, Drawdown{}, ErrNoData},
+	}
+
+	for _, v := range cases {
+		drawdown, err := FindDrawdown(db, brtiTable(t), v.start, v.end)
+		if err != v.err {
+			t.Errorf("%v: FindDrawdown() error = %v, want %v", v.name, err, v.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		v.want.Source = tick.SourceBrti
+		v.want.Product = tick.ProductBtcUsd
+		v.want.Start = v.start
+		v.want.End = v.end
+		if drawdown.MaxDrawdown != v.want.MaxDrawdown && !almostEqual(drawdown.MaxDrawdown, v.want.MaxDrawdown) {
+			t.Errorf("%v: FindDrawdown() = %+v, want %+v", v.name, drawdown, v.want)
+		}
+		drawdown.MaxDrawdown = v.want.MaxDrawdown
+		if drawdown != v.want {
+			t.Errorf("%v: FindDrawdown() = %+v, want %+v", v.name, drawdown, v.want)
+		}
+	}
+}
+
+func TestVolatilityTrackerLateTick(t *testing.T) {
+	db := openTestDB(t)
+	table := brtiTable(t)
+
+	const now = 10000
+
+	// a tick every other minute of the window, the minute in progress is not sampled yet
+	saveTicks(t, db,
+		tick.Tick{Timestamp: 9430, Price: 100},
+		tick.Tick{Timestamp: 9550, Price: 102},
+		tick.Tick{Timestamp: 9670, Price: 101},
+		tick.Tick{Timestamp: 9790, Price: 104},
+		tick.Tick{Timestamp: 9910, Price: 103})
+
+	tracker := NewVolatilityTracker(table, 600, 60)
+
+	steps := []struct {
+		name string
+		ticks []tick.Tick
+		samples int64
+	}{
+		{"first update", nil, 3},
+		{"new tick", []tick.Tick{{Timestamp: 9990, Price: 105}}, 4},
+		{"late tick in an empty minute", []tick.Tick{{Timestamp: 9610, Price: 150}}, 5},
+		{"nothing saved", nil, 5},
+	}
+
+	for _, v := range steps {
+		saveTicks(t, db, v.ticks...)
+
+		err := tracker.update(db, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the tracker updated step by step matches one reading all the ticks at once
+		fresh := NewVolatilityTracker(table, 600, 60)
+		err = fresh.update(db, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		snapshot := tracker.Snapshot()
+		if snapshot.Samples != v.samples || !almostEqual(snapshot.Volatility, fresh.Snapshot().Volatility) {
+			t.Errorf("%v: Snapshot() = %+v, want %v samples and the volatility %v", v.name, snapshot, v.samples, fresh.Snapshot().Volatility)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package batch
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"metrics"
+	"sync"
+	"time"
+	"tracing"
+	"util"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Config flushes the buffered rows when Size rows are buffered or FlushInterval passed since the first one, a transaction for each table.
+// At most QueueSize rows wait while a batch is written, Add blocks when the queue is full.
+// A failed batch is retried as a whole after RetryDelay doubled up to MaxRetryDelay, for Retries times unless the db is busy,
+// then split to give up only the failing rows. Once the writer is closing the busy db is only retried for Retries times too
+type Config struct {
+	Size int
+	FlushInterval string
+	QueueSize int
+	Retries int
+	RetryDelay string
+	MaxRetryDelay string
+}
+
+// Result is a row written with the data it was added with, Saved tells whether it is newly saved or replaced rather than already stored
+type Result struct {
+	Row util.Row
+	Data interface{}
+	Saved bool
+}
+
+// entry is a queued row with the data passed back in its result, ctx is the trace the row was added in
+type entry struct {
+	ctx context.Context
+	row util.Row
+	data interface{}
+}
+
+// Writer groups the rows into periodic transactions, one for each table
+type Writer struct {
+	db *sql.DB
+	size int
+	flushInterval time.Duration
+	retries int
+	retryDelay time.Duration
+	maxRetryDelay time.Duration
+	onWritten func([]Result)
+
+	// mutex keeps the queue open while rows are added, closing releases the callers blocked on the full queue
+	mutex sync.RWMutex
+	queue chan entry
+	closing chan struct{}
+	closeOnce sync.Once
+	done sync.WaitGroup
+}
+
+// New starts the writer, onWritten is called with the results of each written batch from the writer goroutine
+func New(db *sql.DB, config Config, onWritten func([]Result)) *Writer {
+	flushInterval, _ := time.ParseDuration(config.FlushInterval)
+	retryDelay, _ := time.ParseDuration(config.RetryDelay)
+	maxRetryDelay, _ := time.ParseDuration(config.MaxRetryDelay)
+
+	w := &Writer{
+		db: db,
+		size: config.Size,
+		flushInterval: flushInterval,
+		retries: config.Retries,
+		retryDelay: retryDelay,
+		maxRetryDelay: maxRetryDelay,
+		onWritten: onWritten,
+		queue: make(chan entry, config.QueueSize),
+		closing: make(chan struct{}),
+	}
+
+	w.done.Add(1)
+	go w.run()
+
+	return w
+}
+
+// Add queues the row, it blocks while the queue is full so the callers slow down when the db can not keep up.
+// The data is passed back as it is in the result of the row, the span writing the row is linked to the trace of ctx.
+// It returns false when the row is dropped since the writer is closing
+func (w *Writer) Add(ctx context.Context, row util.Row, data interface{}) bool {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	if w.isClosing() {
+		return false
+	}
+
+	select {
+	case w.queue <- entry{ctx, row, data}:
+		return true
+	case <-w.closing:
+		return false
+	}
+}
+
+// Close writes the queued rows and stops the writer, the rows added after are dropped.
+// The rows are given up once their retries are done even if the db is still busy, so closing never hangs on a locked db
+func (w *Writer) Close() {
+	w.closeOnce.Do(func() {
+		close(w.closing)
+
+		w.mutex.Lock()
+		close(w.queue)
+		w.mutex.Unlock()
+	})
+
+	w.done.Wait()
+}
+
+func (w *Writer) isClosing() bool {
+	select {
+	case <-w.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+func (w *Writer) run() {
+	defer w.done.Done()
+
+	var batch []entry
+
+	timer := time.NewTimer(w.flushInterval)
+	timer.Stop()
+
+	for {
+		select {
+		case e, ok := <-w.queue:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+
+			if len(batch) == 0 {
+				timer.Reset(w.flushInterval)
+			}
+
+			batch = append(batch, e)
+			if len(batch) < w.size {
+				continue
+			}
+
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-timer.C:
+		}
+
+		w.flush(batch)
+		batch = nil
+	}
+}
+
+// flush writes the rows of each table in its own transaction, so a transaction never locks more than the db file of the table
+func (w *Writer) flush(batch []entry) {
+	var tables []string
+	byTable := make(map[string][]entry)
+
+	for _, e := range batch {
+		if _, ok := byTable[e.row.Table]; !ok {
+			tables = append(tables, e.row.Table)
+		}
+		byTable[e.row.Table] = append(byTable[e.row.Table], e)
+	}
+
+	for _, table := range tables {
+		w.write(byTable[table], w.retries)
+	}
+}
+
+// write saves the rows in a transaction, retrying them as a whole for the given times.
+// A batch still failing is split in halves written on their own without retries, until the failing row is isolated
+// and retried on its own before it is given up. A batch failing on the busy db while closing is given up at once
+func (w *Writer) write(batch []entry, retries int) {
+	err := w.save(batch, retries)
+	if err == nil {
+		return
+	}
+
+	logger := log.WithFields(log.Fields{"table": batch[0].row.Table, "rows": len(batch)}).WithError(err)
+
+	if len(batch) == 1 || util.IsBusy(err) {
+		if len(batch) == 1 {
+			logger = logger.WithField("args", batch[0].row.Args)
+		}
+		logger.Error("write rows error, give up")
+
+		for _, e := range batch {
+			metrics.ObserveRow(e.row.Table, metrics.RowsFailed)
+		}
+		return
+	}
+
+	logger.Warn("write batch error, split")
+
+	half := len(batch) / 2
+	for _, part := range [][]entry{batch[:half], batch[half:]} {
+		if len(part) == 1 {
+			w.write(part, w.retries)
+		} else {
+			w.write(part, 0)
+		}
+	}
+}
+
+// save writes the batch in a transaction, the busy db is retried until it is released unless closing and the other errors for the given times
+func (w *Writer) save(batch []entry, retries int) error {
+	rows := make([]util.Row, len(batch))
+	linked := make([]context.Context, 0, len(batch))
+	for i := range batch {
+		rows[i] = batch[i].row
+		if batch[i].ctx != nil {
+			linked = append(linked, batch[i].ctx)
+		}
+	}
+
+	delay := w.retryDelay
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+
+		_, span := tracing.StartLinked(context.Background(), "save rows", linked, attribute.String("table", rows[0].Table), attribute.Int("rows", len(rows)), attribute.Int("attempt", attempt+1))
+		result, err := util.SaveRows(w.db, rows)
+		tracing.End(span, err)
+		metrics.ObserveFlush(start, err)
+		if err == nil {
+			results := make([]Result, len(batch))
+			for i := range batch {
+				results[i] = Result{batch[i].row, batch[i].data, result[i]}
+			}
+
+			w.onWritten(results)
+			return nil
+		}
+
+		busy := util.IsBusy(err) && !w.isClosing()
+
+		// the busy db is retried until it is released, the queue fills up in the meantime to slow down the callers
+		if !busy && attempt >= retries {
+			return err
+		}
+
+		log.WithFields(log.Fields{"table": batch[0].row.Table, "rows": len(batch), "attempt": attempt + 1, "busy": busy}).WithError(err).Warn("write batch error, retry")
+		metrics.ObserveFlushRetry()
+
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > w.maxRetryDelay {
+			delay = w.maxRetryDelay
+		}
+	}
+}
+
+// Validate lists all the problems of the config at once
+func (c Config) Validate() error {
+	var problems []string
+
+	if c.Size < 1 {
+		problems = append(problems, "size should be positive")
+	}
+
+	if c.QueueSize < 1 {
+		problems = append(problems, "queueSize should be positive")
+	}
+
+	if c.Retries < 0 {
+		problems = append(problems, "retries should not be negative")
+	}
+
+	var durations []time.Duration
+	for _, v := range []struct {
+		name string
+		value string
+	}{
+		{"flushInterval", c.FlushInterval},
+		{"retryDelay", c.RetryDelay},
+		{"maxRetryDelay", c.MaxRetryDelay},
+	} {
+		d, err := time.ParseDuration(v.value)
+		if err != nil || d <= 0 {
+			problems = append(problems, fmt.Sprintf("%v should be a positive duration like 1s", v.name))
+		}
+		durations = append(durations, d)
+	}
+
+	if durations[2] < durations[1] {
+		problems = append(problems, "maxRetryDelay should not be shorter than retryDelay")
+	}
+
+	if len(problems) > 0 {
+		return errors.New(fmt.Sprintf("invalid storage: %v", problems))
+	}
+
+	return nil
+}
@@ -0,0 +1,277 @@
+package batch
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+	"util"
+)
+
+var testConfig = Config{Size: 3, FlushInterval: "20ms", QueueSize: 10, Retries: 1, RetryDelay: "10ms", MaxRetryDelay: "20ms"}
+
+func openTestDB(t *testing.T) (*sql.DB, string) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := util.OpenDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for _, v := range []string{
+		"CREATE TABLE `a_logs` (`log_time` BIGINT NOT NULL PRIMARY KEY,`log_price` DECIMAL(10,2) NOT NULL)",
+		"CREATE TABLE `b_logs` (`log_time` BIGINT NOT NULL PRIMARY KEY,`log_price` DECIMAL(10,2) NOT NULL)",
+	} {
+		_, err = db.Exec(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return db, dbPath
+}
+
+func testRow(table string, ts int64, price interface{}) util.Row {
+	return util.Row{
+		Table: table,
+		Sql: "INSERT OR IGNORE INTO `" + table + "`(`log_time`,`log_price`) VALUES(?,?)",
+		Args: []interface{}{ts, price},
+	}
+}
+
+// collector records the results passed to onWritten
+type collector struct {
+	mutex sync.Mutex
+	batches int
+	saved map[interface{}]bool
+}
+
+func (c *collector) onWritten(results []Result) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.batches++
+	for _, v := range results {
+		c.saved[v.Data] = v.Saved
+	}
+}
+
+func (c *collector) written() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return len(c.saved)
+}
+
+func count(t *testing.T, db *sql.DB, table string) int {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM `" + table + "`").Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestWriterFlushesBySizeAndInterval(t *testing.T) {
+	db, _ := openTestDB(t)
+	c := &collector{saved: make(map[interface{}]bool)}
+
+	w := New(db, testConfig, c.onWritten)
+
+	// a full batch is flushed at once
+	w.Add(context.Background(), testRow("a_logs", 1, 1.0), "a1")
+	w.Add(context.Background(), testRow("a_logs", 2, 2.0), "a2")
+	w.Add(context.Background(), testRow("a_logs", 3, 3.0), "a3")
+
+	// a partial one after the flush interval
+	w.Add(context.Background(), testRow("a_logs", 3, 3.0), "a3 again")
+
+	deadline := time.Now().Add(time.Second)
+	for c.written() < 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("rows not flushed, %v saved", c.saved)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	w.Close()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.batches != 2 {
+		t.Errorf("%v batches written, want 2", c.batches)
+	}
+	for _, v := range []string{"a1", "a2", "a3"} {
+		if !c.saved[v] {
+			t.Errorf("%v should be saved", v)
+		}
+	}
+	if c.saved["a3 again"] {
+		t.Error("the row already stored should not be saved")
+	}
+}
+
+func TestWriterCloseFlushesQueue(t *testing.T) {
+	db, _ := openTestDB(t)
+	c := &collector{saved: make(map[interface{}]bool)}
+
+	config := testConfig
+	config.FlushInterval = "1h"
+
+	w := New(db, config, c.onWritten)
+	w.Add(context.Background(), testRow("a_logs", 1, 1.0), "a1")
+	w.Close()
+
+	if n := count(t, db, "a_logs"); n != 1 {
+		t.Errorf("%v rows saved on close, want 1", n)
+	}
+}
+
+func TestWriterIsolatesFailingRow(t *testing.T) {
+	db, _ := openTestDB(t)
+	c := &collector{saved: make(map[interface{}]bool)}
+
+	config := testConfig
+	config.Size = 5
+
+	w := New(db, config, c.onWritten)
+	w.Add(context.Background(), testRow("a_logs", 1, 1.0), "a1")
+	w.Add(context.Background(), testRow("b_logs", 1, 1.0), "b1")
+	w.Add(context.Background(), util.Row{Table: "a_logs", Sql: "INSERT INTO `a_logs`(`log_time`,`log_missing`) VALUES(?,?)", Args: []interface{}{2, 2.0}}, "a2 invalid")
+	w.Add(context.Background(), testRow("a_logs", 3, 3.0), "a3")
+	w.Add(context.Background(), testRow("a_logs", 4, 4.0), "a4")
+	w.Close()
+
+	if n := count(t, db, "a_logs"); n != 3 {
+		t.Errorf("%v rows saved in a_logs, want 3", n)
+	}
+	if n := count(t, db, "b_logs"); n != 1 {
+		t.Errorf("%v rows saved in b_logs, want 1", n)
+	}
+
+	for _, v := range []string{"a1", "b1", "a3", "a4"} {
+		if !c.saved[v] {
+			t.Errorf("%v should be saved", v)
+		}
+	}
+	if _, ok := c.saved["a2 invalid"]; ok {
+		t.Error("the failing row should not be reported as written")
+	}
+}
+
+func TestWriterRetriesBusyDB(t *testing.T) {
+	db, dbPath := openTestDB(t)
+	c := &collector{saved: make(map[interface{}]bool)}
+
+	// another connection holds the write lock longer than all the retries for other errors take
+	other, err := util.OpenDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	tx, err := other.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tx.Exec("INSERT INTO `b_logs`(`log_time`,`log_price`) VALUES(100,1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := New(db, testConfig, c.onWritten)
+	w.Add(context.Background(), testRow("a_logs", 1, 1.0), "a1")
+
+	time.Sleep(time.Millisecond * 200)
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.Close()
+
+	if !c.saved["a1"] {
+		t.Error("the row should be saved once the db is released")
+	}
+}
+
+func TestWriterCloseGivesUpBusyDB(t *testing.T) {
+	_, dbPath := openTestDB(t)
+	c := &collector{saved: make(map[interface{}]bool)}
+
+	// another connection holds the write lock until the end of the test
+	other, err := util.OpenDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	tx, err := other.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("INSERT INTO `b_logs`(`log_time`,`log_price`) VALUES(100,1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the driver waits for the lock 5s by default before it reports the db busy
+	db, err := util.OpenDB("file:" + dbPath + "?_busy_timeout=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	config := testConfig
+	config.QueueSize = 1
+
+	w := New(db, config, c.onWritten)
+	w.Add(context.Background(), testRow("a_logs", 1, 1.0), "a1")
+
+	// the adds blocked on the full queue are released by close
+	added := make(chan bool)
+	go func() {
+		for i := int64(2); i < 10; i++ {
+			w.Add(context.Background(), testRow("a_logs", i, 1.0), i)
+		}
+		close(added)
+	}()
+
+	time.Sleep(time.Millisecond * 100)
+
+	closed := make(chan struct{})
+	go func() {
+		w.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Close() hangs on the busy db")
+	}
+	<-added
+
+	if c.written() != 0 {
+		t.Errorf("%v rows written, want none while the db is locked", c.written())
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	if err := testConfig.Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+
+	config := testConfig
+	config.Size = 0
+	config.RetryDelay = "1m"
+	if err := config.Validate(); err == nil {
+		t.Error("Validate() should reject a zero size and a retry delay longer than the max")
+	}
+}
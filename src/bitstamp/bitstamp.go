@@ -4,15 +4,20 @@ import (
 	"database/sql"
 	"util"
 	"fmt"
-	"log"
+	log "github.com/sirupsen/logrus"
 	"net/http"
 	"time"
 	"io/ioutil"
 	"encoding/json"
 	"strconv"
 	"errors"
+	"context"
+	"tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+var logger = log.WithField("source", "bitstamp")
+
 const ProductBtcUsd = "btcusd"
 
 type Ticker struct {
@@ -28,6 +33,12 @@ type HistoricLowest struct {
 	Lowest float64 `json:"lowest"`
 }
 
+type HistoricHighest struct {
+	Start int64 `json:"start"`
+	End int64 `json:"end"`
+	Highest float64 `json:"highest"`
+}
+
 type tickerOriginal struct {
 	Timestamp string `json:"timestamp"`
 	Last string `json:"last"`
@@ -37,12 +48,12 @@ type tickerOriginal struct {
 
 func FindTickerLatest(db *sql.DB, count int32) ([]Ticker, error) {
 	if count < 1 || count > 100 {
-		log.Printf("query count out of range: %v\n", count)
+		logger.WithField("count", count).Warn("query count out of range")
 		return nil, errors.New("query count out of range")
 	}
 	rows, err := db.Query("SELECT `log_time`,`log_price`,`log_low_hourly`,`log_high_hourly` FROM `bitstamp_btcusd_logs` ORDER BY `log_time` DESC LIMIT ?", count)
 	if err != nil {
-		log.Printf("query bitstamp btcusd latest error, error=%v\n", err)
+		logger.WithError(err).Error("query bitstamp btcusd latest error")
 		return nil, err
 	}
 
@@ -58,7 +69,7 @@ func FindTickerLatest(db *sql.DB, count int32) ([]Ticker, error) {
 		err = rows.Scan(&timestamp, &price, &lowHourly, &highHourly)
 
 		if err != nil {
-			log.Printf("read bitstamp btcusd latest error, error=%v\n", err)
+			logger.WithError(err).Error("read bitstamp btcusd latest error")
 			return nil, err
 		}
 
@@ -73,7 +84,7 @@ func FindHistoricLowest(db *sql.DB, tsStart int64, tsEnd int64) (HistoricLowest,
 
 	rows, err := db.Query("SELECT `log_low_hourly` FROM `bitstamp_btcusd_logs` WHERE `log_time` BETWEEN ? AND ? ORDER BY `log_low_hourly` ASC LIMIT 1", tsStart, tsEnd)
 	if err != nil {
-		log.Printf("query bitstamp btcusd lowest error, error=%v\n", err)
+		logger.WithError(err).Error("query bitstamp btcusd lowest error")
 		return result, err
 	}
 
@@ -85,7 +96,7 @@ func FindHistoricLowest(db *sql.DB, tsStart int64, tsEnd int64) (HistoricLowest,
 		err = rows.Scan(&lowest)
 
 		if err != nil {
-			log.Printf("read bitstamp btcusd lowest error, error=%v\n", err)
+			logger.WithError(err).Error("read bitstamp btcusd lowest error")
 			return result, err
 		}
 
@@ -96,38 +107,46 @@ func FindHistoricLowest(db *sql.DB, tsStart int64, tsEnd int64) (HistoricLowest,
 	}
 }
 
-func SaveTicker(db *sql.DB, ticker *Ticker) error {
-	saveSql := "INSERT OR IGNORE INTO `bitstamp_btcusd_logs`(`log_time`,`log_price`,`log_low_hourly`,`log_high_hourly`) VALUES(?,?,?,?)"
-	stmt, err := db.Prepare(saveSql)
+func FindHistoricHighest(db *sql.DB, tsStart int64, tsEnd int64) (HistoricHighest, error) {
+	var result HistoricHighest
+
+	rows, err := db.Query("SELECT `log_high_hourly` FROM `bitstamp_btcusd_logs` WHERE `log_time` BETWEEN ? AND ? ORDER BY `log_high_hourly` DESC LIMIT 1", tsStart, tsEnd)
 	if err != nil {
-		log.Printf("prepare stmt error: %v\n", err)
-		return err
+		logger.WithError(err).Error("query bitstamp btcusd highest error")
+		return result, err
 	}
 
-	defer stmt.Close()
+	defer rows.Close()
 
-	res, err := stmt.Exec(ticker.Timestamp, ticker.Price, ticker.Low, ticker.High)
-	if err != nil {
-		log.Printf("exec save sql error: %v\n", err)
-		return err
-	}
+	if rows.Next() {
+		var highest float64
 
-	affectedRows, err := res.RowsAffected()
-	if err != nil {
-		log.Println(err)
-		return err
-	}
+		err = rows.Scan(&highest)
+
+		if err != nil {
+			logger.WithError(err).Error("read bitstamp btcusd highest error")
+			return result, err
+		}
 
-	if affectedRows > 0 {
-		log.Printf("saved bitstamp btcusd log, %v\n", ticker)
+		result = HistoricHighest{tsStart, tsEnd, highest}
+		return result, nil
+	} else {
+		return result, errors.New("highest historic not found")
 	}
+}
 
-	return nil
+// TickerRow is the row saving the ticker, the stored ticker of the same timestamp is kept
+func TickerRow(ticker *Ticker) util.Row {
+	return util.Row{
+		Table: "bitstamp_btcusd_logs",
+		Sql: "INSERT OR IGNORE INTO `bitstamp_btcusd_logs`(`log_time`,`log_price`,`log_low_hourly`,`log_high_hourly`) VALUES(?,?,?,?)",
+		Args: []interface{}{ticker.Timestamp, ticker.Price, ticker.Low, ticker.High},
+	}
 }
 
-func FetchTicker(product string) (Ticker, error) {
+func FetchTicker(ctx context.Context, product string) (Ticker, error) {
 	url := fmt.Sprintf("https://www.bitstamp.net/api/v2/ticker_hour/%v/", product)
-	log.Printf("Fetch url %v\n", url)
+	logger.WithField("url", url).Debug("fetch url")
 
 	var result Ticker
 
@@ -137,53 +156,58 @@ func FetchTicker(product string) (Ticker, error) {
 
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		log.Println(err)
+		logger.WithError(err).Debug("create request error")
 		return result, err
 	}
 
-	res, err := httpClient.Do(req)
+	_, span := tracing.Start(ctx, "request", attribute.String("url", url))
+	res, err := httpClient.Do(req.WithContext(ctx))
 	if err != nil {
-		log.Println(err)
+		tracing.End(span, err)
+		logger.WithError(err).Debug("request error")
 		return result, err
 	}
 
 	defer res.Body.Close()
 
 	body, err := ioutil.ReadAll(res.Body)
+	tracing.End(span, err)
 	if err != nil {
-		log.Println(err)
+		logger.WithError(err).Debug("read response error")
 		return result, err
 	}
 
 	original := tickerOriginal{}
 
+	_, span = tracing.Start(ctx, "decode")
 	err = json.Unmarshal(body, &original)
+	tracing.End(span, err)
 	if err != nil {
-		log.Println(err)
+		logger.WithError(err).Debug("decode response error")
 		return result, err
 	}
 
 	ts, err := strconv.ParseInt(original.Timestamp, 10, 64)
 	if err != nil {
-		log.Println(err)
+		logger.WithError(err).Debug("parse timestamp error")
 		return result, err
 	}
 
 	price, err := strconv.ParseFloat(original.Last, 64)
 	if err != nil {
-		log.Println(err)
+		logger.WithError(err).Debug("parse last error")
 		return result, err
 	}
 
 	low, err := strconv.ParseFloat(original.Low, 64)
 	if err != nil {
-		log.Println(err)
+		logger.WithError(err).Debug("parse low error")
 		return result, err
 	}
 
 	high, err := strconv.ParseFloat(original.High, 64)
 	if err != nil {
-		log.Println(err)
+		logger.WithError(err).Debug("parse high error")
 		return result, err
 	}
 
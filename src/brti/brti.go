@@ -0,0 +1,98 @@
+package brti
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+	"tracing"
+	"util"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var logger = log.WithField("source", "brti")
+
+const timeLayoutOriginal = "2006-01-02 15:04:05"
+
+type Ticker struct {
+	Timestamp int64 `json:"timestamp"`
+	Price float64 `json:"price"`
+}
+
+type tickerOriginal struct {
+	Value float64 `json:"value"`
+	Date string `json:"date"`
+}
+
+// TickerRow is the row saving the ticker, the stored ticker of the same timestamp is kept
+func TickerRow(ticker *Ticker) util.Row {
+	return util.Row{
+		Table: "brti_logs",
+		Sql: "INSERT OR IGNORE INTO `brti_logs`(`log_time`,`log_price`) VALUES(?,?)",
+		Args: []interface{}{ticker.Timestamp, ticker.Price},
+	}
+}
+
+func FetchTicker(ctx context.Context) (Ticker, error) {
+	url := fmt.Sprintf("https://www.cmegroup.com/CmeWS/mvc/Bitcoin/BRTI?_=%v", time.Now().Unix())
+
+	var result Ticker
+
+	httpClient := http.Client{
+		Timeout: time.Second * 5,
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		logger.WithError(err).Debug("create request error")
+		return result, err
+	}
+
+	_, span := tracing.Start(ctx, "request", attribute.String("url", url))
+	res, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		tracing.End(span, err)
+		logger.WithError(err).Debug("request error")
+		return result, err
+	}
+
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	tracing.End(span, err)
+	if err != nil {
+		logger.WithError(err).Debug("read response error")
+		return result, err
+	}
+
+	original := tickerOriginal{}
+
+	_, span = tracing.Start(ctx, "decode")
+	err = json.Unmarshal(body, &original)
+	tracing.End(span, err)
+	if err != nil {
+		logger.WithError(err).Debug("decode response error")
+		return result, err
+	}
+
+	tm, err := time.Parse(timeLayoutOriginal, original.Date)
+	if err != nil {
+		logger.WithField("date", original.Date).WithError(err).Debug("parse date error")
+		return result, err
+	}
+
+	result = Ticker{tm.Unix(), original.Value}
+
+	return result, nil
+}
+
+func InitDb(db *sql.DB) {
+	util.CheckAndCreateTable(db,
+		"brti_logs",
+		"CREATE TABLE `brti_logs` (`log_time` BIGINT PRIMARY KEY,`log_price` DECIMAL(10,2) NOT NULL,`created_time` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)")
+}
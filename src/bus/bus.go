@@ -0,0 +1,195 @@
+package bus
+
+import (
+	"candle"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	TypeTick = "tick"
+	TypeCandle = "candle"
+	TypeFetchError = "fetch_error"
+	// TypeFetched is published for every successful fetch, even when the tick fetched is already stored and no TypeTick follows
+	TypeFetched = "fetched"
+	TypeAlert = "alert"
+)
+
+// AllChannels subscribes to the events of every source and product
+const AllChannels = "*"
+
+// Event is published by the sources and consumers, Candle and Interval are only set for candles, Error only for fetch errors and Message only for alerts
+type Event struct {
+	Type string `json:"type"`
+	Source string `json:"source"`
+	Product string `json:"product"`
+	Timestamp int64 `json:"timestamp"`
+	Price float64 `json:"price,omitempty"`
+	Interval int64 `json:"interval,omitempty"`
+	Candle *candle.Candle `json:"candle,omitempty"`
+	Error string `json:"error,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Record is the source specific data to store, like the bitstamp ticker with hourly low and high, nil for derived events
+	Record interface{} `json:"-"`
+	// Context carries the trace of the fetch to the consumers, nil for derived events
+	Context context.Context `json:"-"`
+}
+
+// Channel is the subscription key of a source and product, like brti/btcusd
+func Channel(source string, product string) string {
+	return fmt.Sprintf("%v/%v", source, product)
+}
+
+func (e Event) Channel() string {
+	return Channel(e.Source, e.Product)
+}
+
+type counter struct {
+	subscribers int64
+	delivered int64
+	dropped int64
+}
+
+type Stats struct {
+	Name string `json:"name"`
+	Subscribers int64 `json:"subscribers"`
+	Delivered int64 `json:"delivered"`
+	Dropped int64 `json:"dropped"`
+}
+
+type Subscription struct {
+	C chan Event
+
+	name string
+	types map[string]bool
+	counter *counter
+
+	mutex sync.RWMutex
+	channels map[string]bool
+	dropped int64
+}
+
+func (s *Subscription) Add(channel string) {
+	s.mutex.Lock()
+	s.channels[channel] = true
+	s.mutex.Unlock()
+}
+
+func (s *Subscription) Remove(channel string) {
+	s.mutex.Lock()
+	delete(s.channels, channel)
+	s.mutex.Unlock()
+}
+
+func (s *Subscription) accepts(e Event) bool {
+	if len(s.types) > 0 && !s.types[e.Type] {
+		return false
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.channels[AllChannels] || s.channels[e.Channel()]
+}
+
+// Dropped is the number of events dropped since the subscription could not keep up
+func (s *Subscription) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+// Bus fans out the events to the subscriptions through bounded buffers, a subscription too slow to keep up drops events instead of blocking the publishers
+type Bus struct {
+	mutex sync.RWMutex
+	subscriptions map[*Subscription]bool
+	counters map[string]*counter
+}
+
+func New() *Bus {
+	return &Bus{subscriptions: make(map[*Subscription]bool), counters: make(map[string]*counter)}
+}
+
+// Subscribe creates a subscription of the given event types, all types when empty, the name groups the counters of similar subscriptions
+func (b *Bus) Subscribe(name string, buffer int, types []string, channels []string) *Subscription {
+	s := &Subscription{
+		C: make(chan Event, buffer),
+		name: name,
+		types: make(map[string]bool),
+		channels: make(map[string]bool),
+	}
+
+	for _, v := range types {
+		s.types[v] = true
+	}
+
+	for _, v := range channels {
+		s.channels[v] = true
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	c, ok := b.counters[name]
+	if !ok {
+		c = &counter{}
+		b.counters[name] = c
+	}
+	s.counter = c
+	atomic.AddInt64(&c.subscribers, 1)
+
+	b.subscriptions[s] = true
+
+	return s
+}
+
+func (b *Bus) Unsubscribe(s *Subscription) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.subscriptions[s] {
+		delete(b.subscriptions, s)
+		atomic.AddInt64(&s.counter.subscribers, -1)
+	}
+}
+
+func (b *Bus) Publish(e Event) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for s := range b.subscriptions {
+		if !s.accepts(e) {
+			continue
+		}
+
+		select {
+		case s.C <- e:
+			atomic.AddInt64(&s.counter.delivered, 1)
+		default:
+			atomic.AddInt64(&s.dropped, 1)
+			atomic.AddInt64(&s.counter.dropped, 1)
+		}
+	}
+}
+
+// Stats reports the counters of each subscription name since the start
+func (b *Bus) Stats() []Stats {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	result := []Stats{}
+	for name, c := range b.counters {
+		result = append(result, Stats{
+			Name: name,
+			Subscribers: atomic.LoadInt64(&c.subscribers),
+			Delivered: atomic.LoadInt64(&c.delivered),
+			Dropped: atomic.LoadInt64(&c.dropped),
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
@@ -0,0 +1,311 @@
+package candle
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"tick"
+	"time"
+	"util"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MaxCandles is the max number of candles a single query could return
+const MaxCandles = 1000
+
+type Candle struct {
+	Time int64 `json:"time"`
+	Open float64 `json:"open"`
+	High float64 `json:"high"`
+	Low float64 `json:"low"`
+	Close float64 `json:"close"`
+	Count int64 `json:"count"`
+}
+
+// ParseInterval parses interval like 30s, 5m, 1h or 1d into seconds
+func ParseInterval(s string) (int64, error) {
+	var seconds int64
+
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseInt(strings.TrimSuffix(s, "d"), 10, 64)
+		if err != nil {
+			return 0, errors.New(fmt.Sprintf("invalid interval: %v", s))
+		}
+		seconds = days * 86400
+	} else {
+		d, err := time.ParseDuration(s)
+		if err != nil || d%time.Second != 0 {
+			return 0, errors.New(fmt.Sprintf("invalid interval: %v", s))
+		}
+		seconds = int64(d / time.Second)
+	}
+
+	if seconds < 1 {
+		return 0, errors.New(fmt.Sprintf("invalid interval: %v", s))
+	}
+
+	return seconds, nil
+}
+
+// BucketStart returns the start time of the candle which the timestamp belongs to
+func BucketStart(ts int64, interval int64) int64 {
+	if ts < 0 {
+		return (ts - interval + 1) / interval * interval
+	}
+	return ts / interval * interval
+}
+
+// CheckRange makes sure the range is valid and does not contain more than MaxCandles candles
+func CheckRange(interval int64, tsStart int64, tsEnd int64) error {
+	if tsEnd < tsStart {
+		return errors.New("invalid time range")
+	}
+	if (tsEnd-tsStart)/interval+1 > MaxCandles {
+		return errors.New("too many candles in range")
+	}
+	return nil
+}
+
+// Resample builds candles from raw ticks on the fly
+func Resample(db *sql.DB, table tick.Table, interval int64, tsStart int64, tsEnd int64) ([]Candle, error) {
+	err := CheckRange(interval, tsStart, tsEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Candle
+
+	err = Each(db, table, interval, tsStart, tsEnd, func(c Candle) error {
+		result = append(result, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Each resamples the ticks between tsStart and tsEnd in ascending order, only the candle in progress is kept in memory
+func Each(db *sql.DB, table tick.Table, interval int64, tsStart int64, tsEnd int64, fn func(Candle) error) error {
+	return each(db, table, interval, BucketStart(tsStart, interval), tsEnd, fn)
+}
+
+// each resamples the ticks between tsStart and tsEnd, unlike Each the ticks before tsStart in its first bucket are left out
+func each(db tick.Querier, table tick.Table, interval int64, tsStart int64, tsEnd int64, fn func(Candle) error) error {
+	var current *Candle
+
+	err := tick.Each(db, table, tsStart, tsEnd, func(t tick.Tick) error {
+		bucket := BucketStart(t.Timestamp, interval)
+
+		if current == nil || current.Time != bucket {
+			if current != nil {
+				err := fn(*current)
+				if err != nil {
+					return err
+				}
+			}
+
+			current = &Candle{bucket, t.Price, t.Price, t.Price, t.Price, 1}
+			return nil
+		}
+
+		if t.Price > current.High {
+			current.High = t.Price
+		}
+		if t.Price < current.Low {
+			current.Low = t.Price
+		}
+		current.Close = t.Price
+		current.Count++
+
+		return nil
+	})
+	if err != nil {
+		log.WithFields(log.Fields{"source": table.Source, "product": table.Product}).WithError(err).Error("resample candles error")
+		return err
+	}
+
+	if current != nil {
+		return fn(*current)
+	}
+
+	return nil
+}
+
+// Materialize resamples the ticks and stores the candles, candles already stored are replaced since the latest one may be still in progress.
+// The candles rolled up by Rollup are kept, their ticks are deleted so the resampled ones would miss them
+func Materialize(db *sql.DB, table tick.Table, interval int64, tsStart int64, tsEnd int64) ([]Candle, error) {
+	candles, err := Resample(db, table, interval, tsStart, tsEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(candles) == 0 {
+		return nil, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.WithError(err).Error("begin tx error")
+		return nil, err
+	}
+
+	saveSql := "INSERT INTO `candles`(`source`,`product`,`candle_interval`,`log_time`,`log_open`,`log_high`,`log_low`,`log_close`,`log_count`) VALUES(?,?,?,?,?,?,?,?,?) " +
+		"ON CONFLICT(`source`,`product`,`candle_interval`,`log_time`) DO UPDATE SET " +
+		"`log_open`=excluded.`log_open`,`log_high`=excluded.`log_high`,`log_low`=excluded.`log_low`,`log_close`=excluded.`log_close`,`log_count`=excluded.`log_count` " +
+		"WHERE `rolled_up`=0"
+	stmt, err := tx.Prepare(saveSql)
+	if err != nil {
+		log.WithError(err).Error("prepare stmt error")
+		tx.Rollback()
+		return nil, err
+	}
+
+	defer stmt.Close()
+
+	for _, v := range candles {
+		_, err := stmt.Exec(table.Source, table.Product, interval, v.Time, v.Open, v.High, v.Low, v.Close, v.Count)
+		if err != nil {
+			log.WithError(err).Error("exec save sql error")
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.WithError(err).Error("commit tx error")
+		return nil, err
+	}
+
+	return candles, nil
+}
+
+// Rollup merges the candles of the ticks between tsStart and tsEnd into the stored ones within the transaction deleting the ticks.
+// A candle stored by Materialize is replaced, one rolled up before already holds ticks deleted since so it keeps its open,
+// takes the later close, the lowest low, the highest high and adds up the counts. It returns the number of candles rolled up
+func Rollup(tx *sql.Tx, table tick.Table, interval int64, tsStart int64, tsEnd int64) (int64, error) {
+	var candles []Candle
+
+	err := each(tx, table, interval, tsStart, tsEnd, func(c Candle) error {
+		candles = append(candles, c)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if len(candles) == 0 {
+		return 0, nil
+	}
+
+	saveSql := "INSERT INTO `candles`(`source`,`product`,`candle_interval`,`log_time`,`log_open`,`log_high`,`log_low`,`log_close`,`log_count`,`rolled_up`) VALUES(?,?,?,?,?,?,?,?,?,1) " +
+		"ON CONFLICT(`source`,`product`,`candle_interval`,`log_time`) DO UPDATE SET " +
+		"`log_open`=CASE WHEN `rolled_up`=1 THEN `log_open` ELSE excluded.`log_open` END," +
+		"`log_high`=CASE WHEN `rolled_up`=1 THEN MAX(`log_high`,excluded.`log_high`) ELSE excluded.`log_high` END," +
+		"`log_low`=CASE WHEN `rolled_up`=1 THEN MIN(`log_low`,excluded.`log_low`) ELSE excluded.`log_low` END," +
+		"`log_close`=excluded.`log_close`," +
+		"`log_count`=CASE WHEN `rolled_up`=1 THEN `log_count`+excluded.`log_count` ELSE excluded.`log_count` END," +
+		"`rolled_up`=1"
+	stmt, err := tx.Prepare(saveSql)
+	if err != nil {
+		log.WithError(err).Error("prepare stmt error")
+		return 0, err
+	}
+
+	defer stmt.Close()
+
+	for _, v := range candles {
+		_, err := stmt.Exec(table.Source, table.Product, interval, v.Time, v.Open, v.High, v.Low, v.Close, v.Count)
+		if err != nil {
+			log.WithError(err).Error("exec rollup sql error")
+			return 0, err
+		}
+	}
+
+	return int64(len(candles)), nil
+}
+
+// FindMaterialized reads the candles stored by Materialize, the parts of the range not materialized yet are resampled
+func FindMaterialized(db *sql.DB, table tick.Table, interval int64, tsStart int64, tsEnd int64) ([]Candle, error) {
+	err := CheckRange(interval, tsStart, tsEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Candle
+
+	err = EachMaterialized(db, table, interval, tsStart, tsEnd, func(c Candle) error {
+		result = append(result, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// EachMaterialized walks through the candles stored by Materialize in ascending order without loading them all into memory,
+// the gaps between the stored candles are filled by resampling the ticks so a range only partly materialized is still complete
+func EachMaterialized(db *sql.DB, table tick.Table, interval int64, tsStart int64, tsEnd int64, fn func(Candle) error) error {
+	rows, err := db.Query("SELECT `log_time`,`log_open`,`log_high`,`log_low`,`log_close`,`log_count` FROM `candles` WHERE `source`=? AND `product`=? AND `candle_interval`=? AND `log_time` BETWEEN ? AND ? ORDER BY `log_time` ASC",
+		table.Source, table.Product, interval, BucketStart(tsStart, interval), tsEnd)
+	if err != nil {
+		log.WithFields(log.Fields{"source": table.Source, "product": table.Product}).WithError(err).Error("query candles error")
+		return err
+	}
+
+	defer rows.Close()
+
+	// uncovered is the start of the range no stored candle has covered yet
+	uncovered := BucketStart(tsStart, interval)
+
+	for rows.Next() {
+		var c Candle
+
+		err = rows.Scan(&c.Time, &c.Open, &c.High, &c.Low, &c.Close, &c.Count)
+		if err != nil {
+			log.WithFields(log.Fields{"source": table.Source, "product": table.Product}).WithError(err).Error("read candles error")
+			return err
+		}
+
+		if c.Time > uncovered {
+			err = Each(db, table, interval, uncovered, c.Time-1, fn)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = fn(c)
+		if err != nil {
+			return err
+		}
+
+		uncovered = c.Time + interval
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	if uncovered > tsEnd {
+		return nil
+	}
+
+	return Each(db, table, interval, uncovered, tsEnd, fn)
+}
+
+func InitDb(db *sql.DB) {
+	util.CheckAndCreateTable(db,
+		"candles",
+		"CREATE TABLE `candles` (`source` VARCHAR(32) NOT NULL,`product` VARCHAR(32) NOT NULL,`candle_interval` INTEGER NOT NULL,`log_time` BIGINT NOT NULL,`log_open` DECIMAL(10,2) NOT NULL,`log_high` DECIMAL(10,2) NOT NULL,`log_low` DECIMAL(10,2) NOT NULL,`log_close` DECIMAL(10,2) NOT NULL,`log_count` INTEGER NOT NULL,`created_time` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,PRIMARY KEY(`source`,`product`,`candle_interval`,`log_time`))")
+
+	// the candles rolled up from the ticks deleted by the retention
+	util.CheckAndAddColumn(db, "candles", "rolled_up", "INTEGER NOT NULL DEFAULT 0")
+}
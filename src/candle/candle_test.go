@@ -0,0 +1,165 @@
+package candle
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"tick"
+	"util"
+)
+
+var testTable = tick.Table{Source: "test", Product: "btcusd", Name: "test_logs"}
+
+func openTestDB(t *testing.T) *sql.DB {
+	db, err := util.OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec("CREATE TABLE `test_logs` (`log_time` BIGINT NOT NULL PRIMARY KEY,`log_price` DECIMAL(10,2) NOT NULL)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	InitDb(db)
+
+	return db
+}
+
+func insertTicks(t *testing.T, db *sql.DB, ticks ...tick.Tick) {
+	for _, v := range ticks {
+		_, err := db.Exec("INSERT INTO `test_logs`(`log_time`,`log_price`) VALUES(?,?)", v.Timestamp, v.Price)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestParseInterval(t *testing.T) {
+	cases := []struct {
+		s string
+		seconds int64
+		ok bool
+	}{
+		{"30s", 30, true},
+		{"5m", 300, true},
+		{"1h", 3600, true},
+		{"1d", 86400, true},
+		{"1500ms", 0, false},
+		{"0s", 0, false},
+		{"d", 0, false},
+	}
+
+	for _, c := range cases {
+		seconds, err := ParseInterval(c.s)
+		if (err == nil) != c.ok || seconds != c.seconds {
+			t.Errorf("ParseInterval(%q) = %v, %v", c.s, seconds, err)
+		}
+	}
+}
+
+func TestBucketStart(t *testing.T) {
+	cases := []struct {
+		ts int64
+		start int64
+	}{
+		{0, 0},
+		{59, 0},
+		{60, 60},
+		{-1, -60},
+		{-60, -60},
+		{-61, -120},
+	}
+
+	for _, c := range cases {
+		if start := BucketStart(c.ts, 60); start != c.start {
+			t.Errorf("BucketStart(%v, 60) = %v, want %v", c.ts, start, c.start)
+		}
+	}
+}
+
+func TestResample(t *testing.T) {
+	db := openTestDB(t)
+	insertTicks(t, db,
+		tick.Tick{Timestamp: 60, Price: 10},
+		tick.Tick{Timestamp: 70, Price: 12},
+		tick.Tick{Timestamp: 80, Price: 9},
+		tick.Tick{Timestamp: 119, Price: 11},
+		tick.Tick{Timestamp: 240, Price: 20},
+	)
+
+	candles, err := Resample(db, testTable, 60, 90, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Candle{
+		{60, 10, 12, 9, 11, 4},
+		{240, 20, 20, 20, 20, 1},
+	}
+	if !reflect.DeepEqual(candles, want) {
+		t.Errorf("Resample() = %v, want %v", candles, want)
+	}
+}
+
+func TestEachStopsOnError(t *testing.T) {
+	db := openTestDB(t)
+	insertTicks(t, db, tick.Tick{Timestamp: 0, Price: 1}, tick.Tick{Timestamp: 60, Price: 2}, tick.Tick{Timestamp: 120, Price: 3})
+
+	stop := sql.ErrNoRows
+	var calls int
+	err := Each(db, testTable, 60, 0, 180, func(c Candle) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("Each() = %v after %v calls, want %v after 1 call", err, calls, stop)
+	}
+}
+
+func TestResampleTooManyCandles(t *testing.T) {
+	db := openTestDB(t)
+
+	_, err := Resample(db, testTable, 1, 0, MaxCandles)
+	if err == nil {
+		t.Error("Resample() should reject a range of more than MaxCandles candles")
+	}
+}
+
+func TestFindMaterializedFillsGaps(t *testing.T) {
+	db := openTestDB(t)
+	insertTicks(t, db,
+		tick.Tick{Timestamp: 0, Price: 1},
+		tick.Tick{Timestamp: 60, Price: 2},
+		tick.Tick{Timestamp: 120, Price: 3},
+		tick.Tick{Timestamp: 180, Price: 4},
+		tick.Tick{Timestamp: 240, Price: 5},
+	)
+
+	// only the middle candle is materialized, the ones before and after it are resampled
+	_, err := Materialize(db, testTable, 60, 120, 120)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("UPDATE `candles` SET `log_count`=100")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	candles, err := FindMaterialized(db, testTable, 60, 0, 240)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Candle{
+		{0, 1, 1, 1, 1, 1},
+		{60, 2, 2, 2, 2, 1},
+		{120, 3, 3, 3, 3, 100},
+		{180, 4, 4, 4, 4, 1},
+		{240, 5, 5, 5, 5, 1},
+	}
+	if !reflect.DeepEqual(candles, want) {
+		t.Errorf("FindMaterialized() = %v, want %v", candles, want)
+	}
+}
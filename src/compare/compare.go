@@ -0,0 +1,181 @@
+package compare
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"tick"
+)
+
+// MaxPoints is the max number of grid points a single comparison could return
+const MaxPoints = 5000
+
+// Point is the aligned prices at a grid time, spreads and basis are keyed by source and relative to the reference source
+type Point struct {
+	Timestamp int64 `json:"timestamp"`
+	Prices map[string]float64 `json:"prices"`
+	Spreads map[string]float64 `json:"spreads"`
+	Basis map[string]float64 `json:"basis_bps"`
+}
+
+type Summary struct {
+	Source string `json:"source"`
+	Count int64 `json:"count"`
+	MeanSpread float64 `json:"mean_spread"`
+	MinSpread float64 `json:"min_spread"`
+	MaxSpread float64 `json:"max_spread"`
+	StdDevSpread float64 `json:"stddev_spread"`
+	MeanBasis float64 `json:"mean_basis_bps"`
+	MinBasis float64 `json:"min_basis_bps"`
+	MaxBasis float64 `json:"max_basis_bps"`
+}
+
+type Result struct {
+	Reference string `json:"reference"`
+	Start int64 `json:"start"`
+	End int64 `json:"end"`
+	Step int64 `json:"step"`
+	Points []Point `json:"points"`
+	Summary []Summary `json:"summary"`
+}
+
+// CheckRange makes sure the grid is valid and does not contain more than MaxPoints points
+func CheckRange(tsStart int64, tsEnd int64, step int64) error {
+	if step < 1 {
+		return errors.New("invalid step")
+	}
+	if tsEnd < tsStart {
+		return errors.New("invalid time range")
+	}
+	if (tsEnd-tsStart)/step+1 > MaxPoints {
+		return errors.New("too many points in range")
+	}
+	return nil
+}
+
+// align samples the ticks onto the grid, each point takes the latest tick no older than one step
+func align(db *sql.DB, table tick.Table, tsStart int64, tsEnd int64, step int64) ([]float64, []bool, error) {
+	n := int((tsEnd-tsStart)/step + 1)
+	values := make([]float64, n)
+	present := make([]bool, n)
+
+	var last *tick.Tick
+	i := 0
+
+	fill := func(until int64) {
+		for ; i < n && tsStart+int64(i)*step < until; i++ {
+			if last != nil && tsStart+int64(i)*step-last.Timestamp < step {
+				values[i] = last.Price
+				present[i] = true
+			}
+		}
+	}
+
+	err := tick.Each(db, table, tsStart-step+1, tsEnd, func(t tick.Tick) error {
+		fill(t.Timestamp)
+		last = &tick.Tick{Timestamp: t.Timestamp, Price: t.Price}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fill(math.MaxInt64)
+
+	return values, present, nil
+}
+
+// Compare aligns the tables on a common grid, the first table is the reference of spreads and basis
+func Compare(db *sql.DB, tables []tick.Table, tsStart int64, tsEnd int64, step int64) (Result, error) {
+	var result Result
+
+	if len(tables) < 2 {
+		return result, errors.New("at least two sources are required")
+	}
+
+	err := CheckRange(tsStart, tsEnd, step)
+	if err != nil {
+		return result, err
+	}
+
+	values := make([][]float64, len(tables))
+	present := make([][]bool, len(tables))
+
+	for i, table := range tables {
+		values[i], present[i], err = align(db, table, tsStart, tsEnd, step)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	result = Result{Reference: tables[0].Source, Start: tsStart, End: tsEnd, Step: step, Points: []Point{}}
+
+	summaries := make([]Summary, len(tables))
+	m2 := make([]float64, len(tables))
+
+	for j := range values[0] {
+		p := Point{
+			Timestamp: tsStart + int64(j)*step,
+			Prices: map[string]float64{},
+			Spreads: map[string]float64{},
+			Basis: map[string]float64{},
+		}
+
+		for i, table := range tables {
+			if present[i][j] {
+				p.Prices[table.Source] = values[i][j]
+			}
+		}
+
+		if len(p.Prices) == 0 {
+			continue
+		}
+
+		if present[0][j] && values[0][j] != 0 {
+			ref := values[0][j]
+
+			for i := 1; i < len(tables); i++ {
+				if !present[i][j] {
+					continue
+				}
+
+				spread := values[i][j] - ref
+				basis := spread / ref * 10000
+
+				p.Spreads[tables[i].Source] = spread
+				p.Basis[tables[i].Source] = basis
+
+				s := &summaries[i]
+				if s.Count == 0 {
+					s.MinSpread, s.MaxSpread = spread, spread
+					s.MinBasis, s.MaxBasis = basis, basis
+				}
+
+				s.Count++
+				s.MinSpread = math.Min(s.MinSpread, spread)
+				s.MaxSpread = math.Max(s.MaxSpread, spread)
+				s.MinBasis = math.Min(s.MinBasis, basis)
+				s.MaxBasis = math.Max(s.MaxBasis, basis)
+				s.MeanBasis += (basis - s.MeanBasis) / float64(s.Count)
+
+				delta := spread - s.MeanSpread
+				s.MeanSpread += delta / float64(s.Count)
+				m2[i] += delta * (spread - s.MeanSpread)
+			}
+		}
+
+		result.Points = append(result.Points, p)
+	}
+
+	result.Summary = []Summary{}
+	for i := 1; i < len(tables); i++ {
+		s := summaries[i]
+		s.Source = tables[i].Source
+		if s.Count > 0 {
+			s.StdDevSpread = math.Sqrt(m2[i] / float64(s.Count))
+		}
+		result.Summary = append(result.Summary, s)
+	}
+
+	return result, nil
+}
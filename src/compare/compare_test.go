@@ -0,0 +1,111 @@
+package compare
+
+import (
+	"brti"
+	"database/sql"
+	"gdax"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"tick"
+	"util"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	db, err := util.OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	brti.InitDb(db)
+	gdax.InitDb(db)
+
+	return db
+}
+
+func saveTicks(t *testing.T, db *sql.DB, table tick.Table, ticks ...tick.Tick) {
+	for _, v := range ticks {
+		_, err := db.Exec("INSERT INTO `"+table.Name+"`(`log_time`,`log_price`) VALUES(?,?)", v.Timestamp, v.Price)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	db := openTestDB(t)
+	brtiTable, _ := tick.FindTable(tick.SourceBrti, tick.ProductBtcUsd)
+	gdaxTable, _ := tick.FindTable(tick.SourceGdax, tick.ProductBtcUsd)
+
+	saveTicks(t, db, brtiTable,
+		tick.Tick{Timestamp: 95, Price: 100},
+		tick.Tick{Timestamp: 105, Price: 110},
+		tick.Tick{Timestamp: 125, Price: 120})
+	saveTicks(t, db, gdaxTable,
+		tick.Tick{Timestamp: 100, Price: 101},
+		tick.Tick{Timestamp: 112, Price: 99},
+		tick.Tick{Timestamp: 128, Price: 126})
+
+	result, err := Compare(db, []tick.Table{brtiTable, gdaxTable}, 100, 140, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a point takes the latest tick less than a step old, the points without any price are skipped
+	want := []Point{
+		{100, map[string]float64{"brti": 100, "gdax": 101}, map[string]float64{"gdax": 1}, map[string]float64{"gdax": 100}},
+		{110, map[string]float64{"brti": 110}, map[string]float64{}, map[string]float64{}},
+		{120, map[string]float64{"gdax": 99}, map[string]float64{}, map[string]float64{}},
+		{130, map[string]float64{"brti": 120, "gdax": 126}, map[string]float64{"gdax": 6}, map[string]float64{"gdax": 500}},
+	}
+
+	if len(result.Points) != len(want) {
+		t.Fatalf("Compare() = %+v, want %+v", result.Points, want)
+	}
+	for i, v := range want {
+		if !reflect.DeepEqual(result.Points[i], v) {
+			t.Errorf("point %v = %+v, want %+v", i, result.Points[i], v)
+		}
+	}
+
+	summary := Summary{Source: "gdax", Count: 2, MeanSpread: 3.5, MinSpread: 1, MaxSpread: 6, StdDevSpread: 2.5, MeanBasis: 300, MinBasis: 100, MaxBasis: 500}
+	if len(result.Summary) != 1 {
+		t.Fatalf("Compare() summary = %+v, want %+v", result.Summary, summary)
+	}
+
+	s := result.Summary[0]
+	if math.Abs(s.StdDevSpread-summary.StdDevSpread) > 1e-9 {
+		t.Errorf("Compare() summary = %+v, want %+v", s, summary)
+	}
+	s.StdDevSpread = summary.StdDevSpread
+	if s != summary {
+		t.Errorf("Compare() summary = %+v, want %+v", s, summary)
+	}
+}
+
+func TestCompareInvalid(t *testing.T) {
+	db := openTestDB(t)
+	brtiTable, _ := tick.FindTable(tick.SourceBrti, tick.ProductBtcUsd)
+	gdaxTable, _ := tick.FindTable(tick.SourceGdax, tick.ProductBtcUsd)
+
+	cases := []struct {
+		name string
+		tables []tick.Table
+		start int64
+		end int64
+		step int64
+	}{
+		{"single source", []tick.Table{brtiTable}, 0, 100, 10},
+		{"no step", []tick.Table{brtiTable, gdaxTable}, 0, 100, 0},
+		{"reversed range", []tick.Table{brtiTable, gdaxTable}, 100, 0, 10},
+		{"too many points", []tick.Table{brtiTable, gdaxTable}, 0, MaxPoints, 1},
+	}
+
+	for _, v := range cases {
+		if _, err := Compare(db, v.tables, v.start, v.end, v.step); err == nil {
+			t.Errorf("%v: Compare() should fail", v.name)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package export
+
+import (
+	"bufio"
+	"candle"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"tick"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+const (
+	FormatCsv = "csv"
+	FormatParquet = "parquet"
+	FormatNdjson = "ndjson"
+)
+
+const (
+	KindTicks = "ticks"
+	KindCandles = "candles"
+)
+
+// rowGroupSize bounds the rows buffered by the parquet writer before they are written out
+const rowGroupSize = 8 * 1024 * 1024
+
+// ContentTypes are the content types of the formats
+var ContentTypes = map[string]string{
+	FormatCsv: "text/csv",
+	FormatParquet: "application/vnd.apache.parquet",
+	FormatNdjson: "application/x-ndjson",
+}
+
+// Writer writes tick.Tick rows or candle.Candle rows in a format, Close should be called to complete the output
+type Writer interface {
+	Write(row interface{}) error
+	Close() error
+}
+
+// Check makes sure the format and the kind are known
+func Check(format string, kind string) error {
+	if kind != KindTicks && kind != KindCandles {
+		return errors.New(fmt.Sprintf("unknown kind: %v", kind))
+	}
+
+	if _, ok := ContentTypes[format]; !ok {
+		return errors.New(fmt.Sprintf("unknown format: %v", format))
+	}
+
+	return nil
+}
+
+// NewWriter creates the writer of the format for the kind of rows, the parquet writer writes its header at once
+func NewWriter(w io.Writer, format string, kind string) (Writer, error) {
+	err := Check(format, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatCsv:
+		return newCsvWriter(w, kind)
+	case FormatParquet:
+		return newParquetWriter(w, kind)
+	case FormatNdjson:
+		buffered := bufio.NewWriter(w)
+		return &ndjsonWriter{buffered, json.NewEncoder(buffered)}, nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("unknown format: %v", format))
+}
+
+// Export streams the ticks, or the candles of the interval when kind is candles, between tsStart and tsEnd.
+// The candles are read from the materialized ones when materialized, the parts of the range not materialized are resampled
+// as are all the candles otherwise
+func Export(db *sql.DB, w Writer, table tick.Table, kind string, interval int64, materialized bool, tsStart int64, tsEnd int64) error {
+	var err error
+
+	switch {
+	case kind == KindTicks:
+		err = tick.Each(db, table, tsStart, tsEnd, func(t tick.Tick) error {
+			return w.Write(t)
+		})
+	case materialized:
+		err = candle.EachMaterialized(db, table, interval, tsStart, tsEnd, func(c candle.Candle) error {
+			return w.Write(c)
+		})
+	default:
+		err = candle.Each(db, table, interval, tsStart, tsEnd, func(c candle.Candle) error {
+			return w.Write(c)
+		})
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return w.Close()
+}
+
+type csvWriter struct {
+	writer *csv.Writer
+}
+
+func newCsvWriter(w io.Writer, kind string) (*csvWriter, error) {
+	result := &csvWriter{csv.NewWriter(w)}
+
+	header := []string{"timestamp", "price"}
+	if kind == KindCandles {
+		header = []string{"time", "open", "high", "low", "close", "count"}
+	}
+
+	err := result.writer.Write(header)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func formatPrice(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func (w *csvWriter) Write(row interface{}) error {
+	switch v := row.(type) {
+	case tick.Tick:
+		return w.writer.Write([]string{strconv.FormatInt(v.Timestamp, 10), formatPrice(v.Price)})
+	case candle.Candle:
+		return w.writer.Write([]string{strconv.FormatInt(v.Time, 10), formatPrice(v.Open), formatPrice(v.High), formatPrice(v.Low), formatPrice(v.Close), strconv.FormatInt(v.Count, 10)})
+	}
+
+	return errors.New(fmt.Sprintf("unknown row: %T", row))
+}
+
+func (w *csvWriter) Close() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+type ndjsonWriter struct {
+	buffered *bufio.Writer
+	encoder *json.Encoder
+}
+
+func (w *ndjsonWriter) Write(row interface{}) error {
+	return w.encoder.Encode(row)
+}
+
+func (w *ndjsonWriter) Close() error {
+	return w.buffered.Flush()
+}
+
+type tickRow struct {
+	Timestamp int64 `parquet:"name=timestamp, type=INT64"`
+	Price float64 `parquet:"name=price, type=DOUBLE"`
+}
+
+type candleRow struct {
+	Time int64 `parquet:"name=time, type=INT64"`
+	Open float64 `parquet:"name=open, type=DOUBLE"`
+	High float64 `parquet:"name=high, type=DOUBLE"`
+	Low float64 `parquet:"name=low, type=DOUBLE"`
+	Close float64 `parquet:"name=close, type=DOUBLE"`
+	Count int64 `parquet:"name=count, type=INT64"`
+}
+
+type parquetWriter struct {
+	writer *writer.ParquetWriter
+}
+
+func newParquetWriter(w io.Writer, kind string) (*parquetWriter, error) {
+	var schema interface{} = new(tickRow)
+	if kind == KindCandles {
+		schema = new(candleRow)
+	}
+
+	pw, err := writer.NewParquetWriterFromWriter(w, schema, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	pw.RowGroupSize = rowGroupSize
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+
+	return &parquetWriter{pw}, nil
+}
+
+func (w *parquetWriter) Write(row interface{}) error {
+	switch v := row.(type) {
+	case tick.Tick:
+		return w.writer.Write(tickRow{v.Timestamp, v.Price})
+	case candle.Candle:
+		return w.writer.Write(candleRow{v.Time, v.Open, v.High, v.Low, v.Close, v.Count})
+	}
+
+	return errors.New(fmt.Sprintf("unknown row: %T", row))
+}
+
+func (w *parquetWriter) Close() error {
+	return w.writer.WriteStop()
+}
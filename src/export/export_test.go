@@ -0,0 +1,66 @@
+package export
+
+import (
+	"brti"
+	"bytes"
+	"candle"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"tick"
+	"util"
+)
+
+func TestExportPartlyMaterializedCandles(t *testing.T) {
+	db, err := util.OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	brti.InitDb(db)
+	candle.InitDb(db)
+
+	table, _ := tick.FindTable(tick.SourceBrti, tick.ProductBtcUsd)
+
+	for _, ts := range []int64{0, 60, 120, 180} {
+		_, err = db.Exec("INSERT INTO `brti_logs`(`log_time`,`log_price`) VALUES(?,?)", ts, ts+1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// only the second candle is materialized, the others are resampled
+	_, err = candle.Materialize(db, table, 60, 60, 119)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+
+	w, err := NewWriter(&out, FormatNdjson, KindCandles)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Export(db, w, table, KindCandles, 60, true, 0, 239)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var times []int64
+
+	decoder := json.NewDecoder(&out)
+	for decoder.More() {
+		var c candle.Candle
+		err = decoder.Decode(&c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		times = append(times, c.Time)
+	}
+
+	if len(times) != 4 || times[0] != 0 || times[3] != 180 {
+		t.Errorf("exported candles at %v, want every minute from 0 to 180", times)
+	}
+}
@@ -2,7 +2,7 @@ package gdax
 
 import (
 	"fmt"
-	"log"
+	log "github.com/sirupsen/logrus"
 	"net/http"
 	"time"
 	"io/ioutil"
@@ -11,8 +11,13 @@ import (
 	"database/sql"
 	"util"
 	"errors"
+	"context"
+	"tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+var logger = log.WithField("source", "gdax")
+
 const ProductBtcUsd = "BTC-USD"
 
 const timeLayoutOriginal = "2006-01-02T15:04:05.999999Z"
@@ -28,6 +33,16 @@ type Historic struct {
 	High float64 `json:"high"`
 	Open float64 `json:"open"`
 	Close float64 `json:"close"`
+	Volume float64 `json:"volume"`
+}
+
+// VWAP is the volume weighted average price computed from the historic candles, Count is zero when there is no candle in range
+type VWAP struct {
+	Start int64 `json:"start"`
+	End int64 `json:"end"`
+	Price float64 `json:"price"`
+	Volume float64 `json:"volume"`
+	Count int64 `json:"count"`
 }
 
 type tickerOriginal struct {
@@ -37,12 +52,12 @@ type tickerOriginal struct {
 
 func FindTickerLatest(db *sql.DB, count int32) ([]Ticker, error) {
 	if count < 1 || count > 100 {
-		log.Printf("query count out of range: %v\n", count)
+		logger.WithField("count", count).Warn("query count out of range")
 		return nil, errors.New("query count out of range")
 	}
 	rows, err := db.Query("SELECT `log_time`,`log_price` FROM `gdax_btcusd_logs` ORDER BY `log_time` DESC LIMIT ?", count)
 	if err != nil {
-		log.Printf("query gdax btcusd latest error, error=%v\n", err)
+		logger.WithError(err).Error("query gdax btcusd latest error")
 		return nil, err
 	}
 
@@ -56,7 +71,7 @@ func FindTickerLatest(db *sql.DB, count int32) ([]Ticker, error) {
 		err = rows.Scan(&timestamp, &price)
 
 		if err != nil {
-			log.Printf("read gdax btcusd latest error, error=%v\n", err)
+			logger.WithError(err).Error("read gdax btcusd latest error")
 			return nil, err
 		}
 
@@ -69,9 +84,9 @@ func FindTickerLatest(db *sql.DB, count int32) ([]Ticker, error) {
 func FindHistoricLowest(db *sql.DB, tsStart int64, tsEnd int64) (Historic, error) {
 	var result Historic
 
-	rows, err := db.Query("SELECT `log_time`,`log_low`,`log_high`,`log_open`,`log_close` FROM `gdax_btcusd_historic` WHERE `log_time` BETWEEN ? AND ? ORDER BY `log_low` ASC LIMIT 1", tsStart, tsEnd)
+	rows, err := db.Query("SELECT `log_time`,`log_low`,`log_high`,`log_open`,`log_close`,`log_volume` FROM `gdax_btcusd_historic` WHERE `log_time` BETWEEN ? AND ? ORDER BY `log_low` ASC LIMIT 1", tsStart, tsEnd)
 	if err != nil {
-		log.Printf("query gdax btcusd lowest error, error=%v\n", err)
+		logger.WithError(err).Error("query gdax btcusd lowest error")
 		return result, err
 	}
 
@@ -83,58 +98,106 @@ func FindHistoricLowest(db *sql.DB, tsStart int64, tsEnd int64) (Historic, error
 		var high float64
 		var openPrice float64
 		var closePrice float64
+		var volume float64
 
-		err = rows.Scan(&timestamp, &low, &high, &openPrice, &closePrice)
+		err = rows.Scan(&timestamp, &low, &high, &openPrice, &closePrice, &volume)
 
 		if err != nil {
-			log.Printf("read gdax btcusd lowest error, error=%v\n", err)
+			logger.WithError(err).Error("read gdax btcusd lowest error")
 			return result, err
 		}
 
-		result = Historic{timestamp, low, high, openPrice, closePrice}
+		result = Historic{timestamp, low, high, openPrice, closePrice, volume}
 		return result, nil
 	} else {
 		return result, errors.New("lowest historic not found")
 	}
 }
 
-func SaveTicker(db *sql.DB, ticker *Ticker) error {
-	if ticker.Price <= 0 {
-		log.Printf("ignore invalid data: %v\n", ticker)
-		return errors.New("invalid price")
-	}
+func FindHistoricHighest(db *sql.DB, tsStart int64, tsEnd int64) (Historic, error) {
+	var result Historic
 
-	saveSql := "INSERT OR IGNORE INTO `gdax_btcusd_logs`(`log_time`,`log_price`) VALUES(?,?)"
-	stmt, err := db.Prepare(saveSql)
+	rows, err := db.Query("SELECT `log_time`,`log_low`,`log_high`,`log_open`,`log_close`,`log_volume` FROM `gdax_btcusd_historic` WHERE `log_time` BETWEEN ? AND ? ORDER BY `log_high` DESC LIMIT 1", tsStart, tsEnd)
 	if err != nil {
-		log.Printf("prepare stmt error: %v\n", err)
-		return err
+		logger.WithError(err).Error("query gdax btcusd highest error")
+		return result, err
 	}
 
-	defer stmt.Close()
+	defer rows.Close()
 
-	res, err := stmt.Exec(ticker.Timestamp, ticker.Price)
-	if err != nil {
-		log.Printf("exec save sql error: %v\n", err)
-		return err
+	if rows.Next() {
+		var timestamp int64
+		var low float64
+		var high float64
+		var openPrice float64
+		var closePrice float64
+		var volume float64
+
+		err = rows.Scan(&timestamp, &low, &high, &openPrice, &closePrice, &volume)
+
+		if err != nil {
+			logger.WithError(err).Error("read gdax btcusd highest error")
+			return result, err
+		}
+
+		result = Historic{timestamp, low, high, openPrice, closePrice, volume}
+		return result, nil
+	} else {
+		return result, errors.New("highest historic not found")
 	}
+}
+
+func FindVWAP(db *sql.DB, tsStart int64, tsEnd int64) (VWAP, error) {
+	var result VWAP
 
-	affectedRows, err := res.RowsAffected()
+	// typical price of each candle weighted by its volume
+	rows, err := db.Query("SELECT SUM((`log_high`+`log_low`+`log_close`)/3.0*`log_volume`),SUM(`log_volume`),COUNT(*) FROM `gdax_btcusd_historic` WHERE `log_time` BETWEEN ? AND ? AND `log_volume` > 0", tsStart, tsEnd)
 	if err != nil {
-		log.Println(err)
-		return err
+		logger.WithError(err).Error("query gdax btcusd vwap error")
+		return result, err
 	}
 
-	if affectedRows > 0 {
-		log.Printf("saved gdax btcusd log, %v\n", ticker)
+	defer rows.Close()
+
+	if rows.Next() {
+		var amount sql.NullFloat64
+		var volume sql.NullFloat64
+		var count int64
+
+		err = rows.Scan(&amount, &volume, &count)
+
+		if err != nil {
+			logger.WithError(err).Error("read gdax btcusd vwap error")
+			return result, err
+		}
+
+		if count > 0 && volume.Float64 > 0 {
+			result = VWAP{tsStart, tsEnd, amount.Float64 / volume.Float64, volume.Float64, count}
+			return result, nil
+		}
 	}
 
-	return nil
+	result = VWAP{Start: tsStart, End: tsEnd}
+	return result, nil
 }
 
-func FetchTicker(product string) (Ticker, error)  {
+// TickerRow is the row saving the ticker, the stored ticker of the same timestamp is kept
+func TickerRow(ticker *Ticker) (util.Row, error) {
+	if ticker.Price <= 0 {
+		logger.WithFields(log.Fields{"timestamp": ticker.Timestamp, "price": ticker.Price}).Warn("ignore invalid ticker")
+		return util.Row{}, errors.New("invalid price")
+	}
+
+	return util.Row{
+		Table: "gdax_btcusd_logs",
+		Sql: "INSERT OR IGNORE INTO `gdax_btcusd_logs`(`log_time`,`log_price`) VALUES(?,?)",
+		Args: []interface{}{ticker.Timestamp, ticker.Price},
+	}, nil
+}
+
+func FetchTicker(ctx context.Context, product string) (Ticker, error)  {
 	url := fmt.Sprintf("https://api.gdax.com/products/%v/ticker", product)
-	log.Printf("Fetch url %v\n", url)
+	logger.WithField("url", url).Debug("fetch url")
 
 	var result Ticker
 
@@ -144,35 +207,40 @@ func FetchTicker(product string) (Ticker, error)  {
 
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		log.Println(err)
+		logger.WithError(err).Debug("create request error")
 		return result, err
 	}
 
-	res, err := httpClient.Do(req)
+	_, span := tracing.Start(ctx, "request", attribute.String("url", url))
+	res, err := httpClient.Do(req.WithContext(ctx))
 	if err != nil {
-		log.Println(err)
+		tracing.End(span, err)
+		logger.WithError(err).Debug("request error")
 		return result, err
 	}
 
 	defer res.Body.Close()
 
 	body, err := ioutil.ReadAll(res.Body)
+	tracing.End(span, err)
 	if err != nil {
-		log.Println(err)
+		logger.WithError(err).Debug("read response error")
 		return result, err
 	}
 
 	original := tickerOriginal{}
 
+	_, span = tracing.Start(ctx, "decode")
 	err = json.Unmarshal(body, &original)
+	tracing.End(span, err)
 	if err != nil {
-		log.Println(err)
+		logger.WithError(err).Debug("decode response error")
 		return result, err
 	}
 
 	price, err := strconv.ParseFloat(original.Price, 64)
 	if err != nil {
-		log.Println(err)
+		logger.WithError(err).Debug("parse price error")
 		return result, err
 	}
 
@@ -183,37 +251,27 @@ func FetchTicker(product string) (Ticker, error)  {
 	return result, nil
 }
 
-func SaveHistoric(db *sql.DB, historics []Historic) error {
-	saveSql := "INSERT OR IGNORE INTO `gdax_btcusd_historic`(`log_time`,`log_low`,`log_high`,`log_open`,`log_close`) VALUES(?,?,?,?,?)"
-	stmt, err := db.Prepare(saveSql)
-	if err != nil {
-		log.Printf("prepare stmt error: %v\n", err)
-		return err
+// HistoricRow is the row saving the candle, it replaces the stored candle since the latest one is still in progress when fetched
+func HistoricRow(historic *Historic) (util.Row, error) {
+	if historic.Open <= 0 {
+		logger.WithField("time", historic.Time).Warn("ignore invalid historic")
+		return util.Row{}, errors.New("invalid historic")
 	}
 
-	defer stmt.Close()
-
-	for _, v := range historics {
-		if v.Open <= 0 {
-			log.Printf("ignore invalid data: %v\n", v)
-			continue
-		}
-		_, err := stmt.Exec(v.Time, v.Low, v.High, v.Open, v.Close)
-		if err != nil {
-			log.Printf("exec save sql error: %v\n", err)
-			return err
-		}
-	}
-
-	return nil
+	return util.Row{
+		Table: "gdax_btcusd_historic",
+		Sql: "INSERT OR REPLACE INTO `gdax_btcusd_historic`(`log_time`,`log_low`,`log_high`,`log_open`,`log_close`,`log_volume`) VALUES(?,?,?,?,?,?)",
+		Args: []interface{}{historic.Time, historic.Low, historic.High, historic.Open, historic.Close, historic.Volume},
+		Replace: true,
+	}, nil
 }
 
-func FetchHistoric(product string, tsStart int64, tsEnd int64) ([]Historic, error) {
+func FetchHistoric(ctx context.Context, product string, tsStart int64, tsEnd int64) ([]Historic, error) {
 	tmStart := time.Unix(tsStart, 0).UTC()
 	tmEnd := time.Unix(tsEnd, 0).UTC()
 
 	url := fmt.Sprintf("https://api.gdax.com/products/%v/candles?start=%v&end=%v", product, tmStart.Format(timeLayoutOriginal), tmEnd.Format(timeLayoutOriginal))
-	log.Printf("Fetch url %v\n", url)
+	logger.WithField("url", url).Debug("fetch url")
 
 	var result []Historic
 
@@ -223,34 +281,44 @@ func FetchHistoric(product string, tsStart int64, tsEnd int64) ([]Historic, erro
 
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		log.Println(err)
+		logger.WithError(err).Debug("create request error")
 		return result, err
 	}
 
-	res, err := httpClient.Do(req)
+	_, span := tracing.Start(ctx, "request", attribute.String("url", url))
+	res, err := httpClient.Do(req.WithContext(ctx))
 	if err != nil {
-		log.Println(err)
+		tracing.End(span, err)
+		logger.WithError(err).Debug("request error")
 		return result, err
 	}
 
 	defer res.Body.Close()
 
 	body, err := ioutil.ReadAll(res.Body)
+	tracing.End(span, err)
 	if err != nil {
-		log.Println(err)
+		logger.WithError(err).Debug("read response error")
 		return result, err
 	}
 
 	var original [][]float64
 
+	_, span = tracing.Start(ctx, "decode")
 	err = json.Unmarshal(body, &original)
+	tracing.End(span, err)
 	if err != nil {
-		log.Println(err)
+		logger.WithError(err).Debug("decode response error")
 		return result, err
 	}
 
+	// each candle is [time, low, high, open, close, volume]
 	for _, v := range original {
-		result = append(result, Historic{int64(v[0]), v[1], v[2], v[3], v[4]})
+		if len(v) < 6 {
+			logger.WithField("data", v).Warn("ignore invalid historic")
+			continue
+		}
+		result = append(result, Historic{int64(v[0]), v[1], v[2], v[3], v[4], v[5]})
 	}
 
 	return result, nil
@@ -263,7 +331,9 @@ func InitDb(db *sql.DB)  {
 
 	util.CheckAndCreateTable(db,
 		"gdax_btcusd_historic",
-		"CREATE TABLE `gdax_btcusd_historic` (`log_time` BIGINT PRIMARY KEY,`log_low` DECIMAL(10,2) NOT NULL,`log_high` DECIMAL(10,2) NOT NULL,`log_open` DECIMAL(10,2) NOT NULL,`log_close` DECIMAL(10,2) NOT NULL,`created_time` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)")
+		"CREATE TABLE `gdax_btcusd_historic` (`log_time` BIGINT PRIMARY KEY,`log_low` DECIMAL(10,2) NOT NULL,`log_high` DECIMAL(10,2) NOT NULL,`log_open` DECIMAL(10,2) NOT NULL,`log_close` DECIMAL(10,2) NOT NULL,`log_volume` DECIMAL(20,8) NOT NULL DEFAULT 0,`created_time` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)")
+
+	util.CheckAndAddColumn(db, "gdax_btcusd_historic", "log_volume", "DECIMAL(20,8) NOT NULL DEFAULT 0")
 
 	util.ExecuteStmtSql(db, "CREATE INDEX IF NOT EXISTS idx_high ON `gdax_btcusd_historic`(`log_high`)")
 
@@ -0,0 +1,234 @@
+package health
+
+import (
+	"bus"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"tick"
+	"time"
+)
+
+const (
+	StatusOk = "ok"
+	// StatusStale is reported when a source has not been fetched or has not moved within its SLA
+	StatusStale = "stale"
+	// StatusWaiting is reported until the first tick of a source within its SLA
+	StatusWaiting = "waiting"
+)
+
+// SLA is the freshness required for a source, MaxFetchAge and MaxTickAge are in seconds,
+// the report is unhealthy when a Critical source is stale
+type SLA struct {
+	Source string `json:"source"`
+	Product string `json:"product"`
+	MaxFetchAge int64 `json:"maxFetchAge"`
+	MaxTickAge int64 `json:"maxTickAge"`
+	Critical bool `json:"critical"`
+}
+
+// Validate lists all the problems of the SLA at once
+func (s SLA) Validate() error {
+	var problems []string
+
+	_, err := tick.FindTable(s.Source, s.Product)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if s.MaxFetchAge < 1 {
+		problems = append(problems, "maxFetchAge should be positive")
+	}
+
+	if s.MaxTickAge < 1 {
+		problems = append(problems, "maxTickAge should be positive")
+	}
+
+	if len(problems) > 0 {
+		return errors.New(fmt.Sprintf("invalid sla %v %v: %v", s.Source, s.Product, problems))
+	}
+
+	return nil
+}
+
+// Source is the freshness of a source, the times are unix seconds of the wall clock and 0 before it happened.
+// LastTick is when a tick with a new timestamp was fetched, LastChange is when the price was different from the previous tick
+type Source struct {
+	Source string `json:"source"`
+	Product string `json:"product"`
+	Status string `json:"status"`
+	Critical bool `json:"critical"`
+	LastFetch int64 `json:"lastFetch"`
+	LastTick int64 `json:"lastTick"`
+	LastChange int64 `json:"lastChange"`
+	Timestamp int64 `json:"timestamp"`
+	Price float64 `json:"price"`
+	Errors int64 `json:"errors"`
+	ConsecutiveErrors int64 `json:"consecutiveErrors"`
+	LastError string `json:"lastError,omitempty"`
+	LastErrorTime int64 `json:"lastErrorTime,omitempty"`
+	SLA SLA `json:"sla"`
+
+	// added is when the source started being tracked, the SLA counts from then before the first fetch
+	added int64
+}
+
+type Report struct {
+	Healthy bool `json:"healthy"`
+	Ready bool `json:"ready"`
+	Started int64 `json:"started"`
+	Time int64 `json:"time"`
+	Sources []Source `json:"sources"`
+}
+
+// Tracker follows the fetches and fetch errors of the sources with an SLA
+type Tracker struct {
+	started int64
+
+	mutex sync.Mutex
+	sources map[string]*Source
+}
+
+func NewTracker(slas []SLA) (*Tracker, error) {
+	t := &Tracker{started: time.Now().Unix(), sources: make(map[string]*Source)}
+
+	err := t.Update(slas)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Update replaces the SLAs, the state of the sources kept is not reset and the new ones count their SLA from now
+func (t *Tracker) Update(slas []SLA) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	err := Check(slas)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+
+	sources := make(map[string]*Source)
+	for _, s := range slas {
+		channel := bus.Channel(s.Source, s.Product)
+		source, ok := t.sources[channel]
+		if !ok {
+			source = &Source{Source: s.Source, Product: s.Product, added: now}
+		}
+		source.Critical = s.Critical
+		source.SLA = s
+
+		sources[channel] = source
+	}
+
+	t.sources = sources
+
+	return nil
+}
+
+// Check tells whether Update would accept the SLAs, each source and product has a single valid SLA
+func Check(slas []SLA) error {
+	channels := make(map[string]bool)
+	for _, s := range slas {
+		err := s.Validate()
+		if err != nil {
+			return err
+		}
+
+		channel := bus.Channel(s.Source, s.Product)
+		if channels[channel] {
+			return errors.New(fmt.Sprintf("duplicated sla: %v", channel))
+		}
+		channels[channel] = true
+	}
+
+	return nil
+}
+
+// Run tracks the events of the subscription until it is closed
+func (t *Tracker) Run(subscription *bus.Subscription) {
+	for e := range subscription.C {
+		t.track(e, time.Now().Unix())
+	}
+}
+
+func (t *Tracker) track(e bus.Event, now int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	s, ok := t.sources[e.Channel()]
+	if !ok {
+		return
+	}
+
+	switch e.Type {
+	case bus.TypeFetched:
+		// the fetches are tracked rather than the ticks saved, a tick fetched again is not saved but the source is fresh
+		s.LastFetch = now
+		s.ConsecutiveErrors = 0
+
+		if e.Timestamp > s.Timestamp {
+			s.LastTick = now
+			if e.Price != s.Price {
+				s.LastChange = now
+			}
+			s.Timestamp = e.Timestamp
+			s.Price = e.Price
+		}
+	case bus.TypeFetchError:
+		s.Errors++
+		s.ConsecutiveErrors++
+		s.LastError = e.Error
+		s.LastErrorTime = now
+	}
+}
+
+// Report checks the sources against their SLA, before the first fetch the SLA counts from when the source was added
+func (t *Tracker) Report() Report {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now().Unix()
+
+	result := Report{Healthy: true, Ready: true, Started: t.started, Time: now, Sources: []Source{}}
+
+	for _, s := range t.sources {
+		v := *s
+
+		lastFetch := v.LastFetch
+		lastTick := v.LastTick
+		if lastTick == 0 {
+			lastFetch = v.added
+			lastTick = v.added
+		}
+
+		if now-lastFetch > v.SLA.MaxFetchAge || now-lastTick > v.SLA.MaxTickAge {
+			v.Status = StatusStale
+		} else if v.LastTick == 0 {
+			v.Status = StatusWaiting
+		} else {
+			v.Status = StatusOk
+		}
+
+		if v.Critical && v.Status == StatusStale {
+			result.Healthy = false
+		}
+
+		if v.Critical && v.Status != StatusOk {
+			result.Ready = false
+		}
+
+		result.Sources = append(result.Sources, v)
+	}
+
+	sort.Slice(result.Sources, func(i, j int) bool {
+		return bus.Channel(result.Sources[i].Source, result.Sources[i].Product) < bus.Channel(result.Sources[j].Source, result.Sources[j].Product)
+	})
+
+	return result
+}
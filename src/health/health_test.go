@@ -0,0 +1,121 @@
+package health
+
+import (
+	"bus"
+	"testing"
+	"tick"
+	"time"
+)
+
+var testSLA = SLA{Source: tick.SourceGdax, Product: tick.ProductBtcUsd, MaxFetchAge: 60, MaxTickAge: 300, Critical: true}
+
+func fetched(ts int64, price float64) bus.Event {
+	return bus.Event{Type: bus.TypeFetched, Source: tick.SourceGdax, Product: tick.ProductBtcUsd, Timestamp: ts, Price: price}
+}
+
+func fetchError() bus.Event {
+	return bus.Event{Type: bus.TypeFetchError, Source: tick.SourceGdax, Product: tick.ProductBtcUsd, Error: "timeout"}
+}
+
+func TestDuplicateFetchKeepsSourceFresh(t *testing.T) {
+	tracker, err := NewTracker([]SLA{testSLA})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Unix()
+
+	// the same tick is fetched again after an error, it is not saved again but the fetch succeeded
+	tracker.track(fetched(100, 1), now-20)
+	tracker.track(fetchError(), now-10)
+	tracker.track(fetched(100, 1), now)
+
+	report := tracker.Report()
+	s := report.Sources[0]
+
+	if s.ConsecutiveErrors != 0 || s.Errors != 1 {
+		t.Errorf("%v consecutive errors and %v errors, want 0 and 1", s.ConsecutiveErrors, s.Errors)
+	}
+	if s.LastFetch != now || s.LastTick != now-20 {
+		t.Errorf("last fetch %v and last tick %v, want the duplicate fetch to only refresh the fetch", s.LastFetch-now, s.LastTick-now)
+	}
+	if s.Status != StatusOk || !report.Healthy || !report.Ready {
+		t.Errorf("report %+v, want the source ok", report)
+	}
+}
+
+func TestReportStatus(t *testing.T) {
+	type tracked struct {
+		event bus.Event
+		ago int64
+	}
+
+	cases := []struct {
+		name string
+		critical bool
+		added int64
+		events []tracked
+		status string
+		healthy bool
+		ready bool
+	}{
+		{"just added", true, 0, nil, StatusWaiting, true, false},
+		{"never fetched within the fetch age", true, 61, nil, StatusStale, false, false},
+		{"only errors", true, 10, []tracked{{fetchError(), 5}}, StatusWaiting, true, false},
+		{"fetched", true, 100, []tracked{{fetched(100, 1), 10}}, StatusOk, true, true},
+		{"fetch too old", true, 100, []tracked{{fetched(100, 1), 61}}, StatusStale, false, false},
+		{"tick too old", true, 400, []tracked{{fetched(100, 1), 301}, {fetched(100, 1), 0}}, StatusStale, false, false},
+		{"older tick fetched", true, 400, []tracked{{fetched(100, 1), 301}, {fetched(90, 2), 0}}, StatusStale, false, false},
+		{"new tick", true, 400, []tracked{{fetched(100, 1), 301}, {fetched(101, 1), 0}}, StatusOk, true, true},
+		{"stale source not critical", false, 61, nil, StatusStale, true, true},
+	}
+
+	for _, v := range cases {
+		sla := testSLA
+		sla.Critical = v.critical
+
+		tracker, err := NewTracker([]SLA{sla})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		now := time.Now().Unix()
+		tracker.sources[bus.Channel(sla.Source, sla.Product)].added = now - v.added
+
+		for _, e := range v.events {
+			tracker.track(e.event, now-e.ago)
+		}
+
+		report := tracker.Report()
+		if len(report.Sources) != 1 || report.Sources[0].Status != v.status || report.Healthy != v.healthy || report.Ready != v.ready {
+			t.Errorf("%v: Report() = %+v, want %v, healthy %v and ready %v", v.name, report, v.status, v.healthy, v.ready)
+		}
+	}
+}
+
+func TestUpdateKeepsTrackedSources(t *testing.T) {
+	tracker, err := NewTracker([]SLA{testSLA})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracker.track(fetched(100, 1), time.Now().Unix())
+
+	bitstamp := SLA{Source: tick.SourceBitstamp, Product: tick.ProductBtcUsd, MaxFetchAge: 60, MaxTickAge: 300}
+	err = tracker.Update([]SLA{testSLA, bitstamp})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := tracker.Report()
+	if len(report.Sources) != 2 || report.Sources[0].Source != tick.SourceBitstamp || report.Sources[1].Timestamp != 100 {
+		t.Errorf("Report() = %+v, want bitstamp added and the gdax state kept", report)
+	}
+
+	if err = tracker.Update([]SLA{testSLA, testSLA}); err == nil {
+		t.Error("Update() should reject a duplicated sla")
+	}
+	if len(tracker.Report().Sources) != 2 {
+		t.Error("a rejected Update() should keep the sources")
+	}
+}
@@ -0,0 +1,396 @@
+package importer
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"util"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// TimeUnix reads the time as unix seconds
+	TimeUnix = "unix"
+	// TimeUnixMs reads the time as unix milliseconds
+	TimeUnixMs = "unix_ms"
+)
+
+// maxListed is the max number of rejects and duplicates listed in a report, the others are only counted
+const maxListed = 100
+
+// the batches failing on a db locked by another connection, like the running fetcher, are retried with a growing delay
+const (
+	busyRetries = 10
+	retryDelay = time.Millisecond * 100
+	maxRetryDelay = time.Second * 5
+)
+
+// Target is a table the csv rows can be imported into, Optional columns default to 0 when not mapped or empty
+type Target struct {
+	Table string
+	Columns []string
+	Optional map[string]bool
+	validate func(values map[string]float64) error
+}
+
+var Targets = map[string]Target{
+	"brti_logs": {
+		Table: "brti_logs",
+		Columns: []string{"log_time", "log_price"},
+		validate: checkPositive("log_price"),
+	},
+	"bitstamp_btcusd_logs": {
+		Table: "bitstamp_btcusd_logs",
+		Columns: []string{"log_time", "log_price", "log_low_hourly", "log_high_hourly"},
+		validate: func(values map[string]float64) error {
+			err := checkPositive("log_price", "log_low_hourly", "log_high_hourly")(values)
+			if err != nil {
+				return err
+			}
+			return checkRange(values, "log_low_hourly", "log_high_hourly")
+		},
+	},
+	"gdax_btcusd_historic": {
+		Table: "gdax_btcusd_historic",
+		Columns: []string{"log_time", "log_low", "log_high", "log_open", "log_close", "log_volume"},
+		Optional: map[string]bool{"log_volume": true},
+		validate: func(values map[string]float64) error {
+			err := checkPositive("log_low", "log_high", "log_open", "log_close")(values)
+			if err != nil {
+				return err
+			}
+			if values["log_volume"] < 0 {
+				return errors.New("log_volume should not be negative")
+			}
+			return checkRange(values, "log_low", "log_high", "log_open", "log_close")
+		},
+	},
+}
+
+func checkPositive(columns ...string) func(values map[string]float64) error {
+	return func(values map[string]float64) error {
+		for _, v := range columns {
+			if values[v] <= 0 {
+				return errors.New(fmt.Sprintf("%v should be positive", v))
+			}
+		}
+		return nil
+	}
+}
+
+// checkRange makes sure the low is not above the high and the prices are within them
+func checkRange(values map[string]float64, low string, high string, prices ...string) error {
+	if values[low] > values[high] {
+		return errors.New(fmt.Sprintf("%v should not be above %v", low, high))
+	}
+
+	for _, v := range prices {
+		if values[v] < values[low] || values[v] > values[high] {
+			return errors.New(fmt.Sprintf("%v should be between %v and %v", v, low, high))
+		}
+	}
+
+	return nil
+}
+
+// Options maps the columns of the table to the csv headers, the columns not in Mapping are read from the headers of the same name.
+// TimeFormat is unix, unix_ms or a layout like 2006-01-02 15:04:05 read in UTC
+type Options struct {
+	Mapping map[string]string
+	TimeFormat string
+	Delimiter rune
+	BatchSize int
+}
+
+// Reject is a csv row which was not imported, Line is where the row starts counted from 1 including the header
+type Reject struct {
+	Line int64 `json:"line"`
+	Reason string `json:"reason"`
+}
+
+// Report counts the csv rows read, the rows inserted, the duplicates already stored and the rejects,
+// at most 100 duplicate lines and rejects are listed
+type Report struct {
+	Table string `json:"table"`
+	Rows int64 `json:"rows"`
+	Inserted int64 `json:"inserted"`
+	Duplicates int64 `json:"duplicates"`
+	DuplicateLines []int64 `json:"duplicateLines"`
+	Rejected int64 `json:"rejected"`
+	Rejects []Reject `json:"rejects"`
+}
+
+func (r *Report) reject(line int64, reason string) {
+	r.Rejected++
+	if len(r.Rejects) < maxListed {
+		r.Rejects = append(r.Rejects, Reject{line, reason})
+	}
+}
+
+func (r *Report) duplicate(line int64) {
+	r.Duplicates++
+	if len(r.DuplicateLines) < maxListed {
+		r.DuplicateLines = append(r.DuplicateLines, line)
+	}
+}
+
+// parseTime reads the time in unix seconds
+func parseTime(s string, format string) (int64, error) {
+	switch format {
+	case TimeUnix, "":
+		return strconv.ParseInt(s, 10, 64)
+	case TimeUnixMs:
+		ms, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return ms / 1000, nil
+	}
+
+	tm, err := time.Parse(format, s)
+	if err != nil {
+		return 0, err
+	}
+	return tm.Unix(), nil
+}
+
+// row is a validated csv row with its values in the order of the target columns
+type row struct {
+	line int64
+	values []interface{}
+}
+
+// Import reads the csv rows into the target table, the rows already stored are kept and reported as duplicates.
+// Each batch is inserted in a transaction, the import stops at the first failed batch and the report counts the batches committed before
+func Import(db *sql.DB, r io.Reader, target Target, options Options) (Report, error) {
+	report := Report{Table: target.Table}
+
+	reader := csv.NewReader(r)
+	if options.Delimiter != 0 {
+		reader.Comma = options.Delimiter
+	}
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return report, errors.New(fmt.Sprintf("read csv header error: %v", err))
+	}
+
+	positions := make(map[string]int)
+	for i, v := range header {
+		positions[strings.TrimSpace(v)] = i
+	}
+
+	// the position of each column in the csv rows, -1 for the optional columns not found
+	indexes := make([]int, len(target.Columns))
+	var missing []string
+	for i, column := range target.Columns {
+		name := column
+		if mapped, ok := options.Mapping[column]; ok {
+			name = mapped
+		}
+
+		index, ok := positions[name]
+		if !ok {
+			if !target.Optional[column] {
+				missing = append(missing, fmt.Sprintf("%v (%v)", column, name))
+			}
+			index = -1
+		}
+		indexes[i] = index
+	}
+
+	if len(missing) > 0 {
+		return report, errors.New(fmt.Sprintf("columns not found in csv header: %v", strings.Join(missing, ", ")))
+	}
+
+	batchSize := options.BatchSize
+	if batchSize < 1 {
+		batchSize = 1000
+	}
+
+	var batch []row
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		report.Rows++
+
+		if err != nil {
+			// a malformed row does not stop the reader, the next one is read after it
+			var line int64
+			if e, ok := err.(*csv.ParseError); ok {
+				line = int64(e.StartLine)
+			}
+			report.reject(line, err.Error())
+			continue
+		}
+
+		// a quoted field may span several lines, the line is where the row starts
+		start, _ := reader.FieldPos(0)
+		line := int64(start)
+
+		values, err := parseRecord(record, target, indexes, options.TimeFormat)
+		if err != nil {
+			report.reject(line, err.Error())
+			continue
+		}
+
+		batch = append(batch, row{line, values})
+		if len(batch) >= batchSize {
+			err = insert(db, target, batch, &report)
+			if err != nil {
+				return report, err
+			}
+			batch = batch[:0]
+		}
+	}
+
+	if len(batch) > 0 {
+		err = insert(db, target, batch, &report)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+func parseRecord(record []string, target Target, indexes []int, timeFormat string) ([]interface{}, error) {
+	values := make([]interface{}, len(target.Columns))
+	numbers := make(map[string]float64)
+
+	for i, column := range target.Columns {
+		var field string
+		if indexes[i] >= 0 {
+			if indexes[i] >= len(record) {
+				return nil, errors.New(fmt.Sprintf("%v is missing", column))
+			}
+			field = strings.TrimSpace(record[indexes[i]])
+		}
+
+		if field == "" && target.Optional[column] {
+			values[i] = 0
+			continue
+		}
+
+		if i == 0 {
+			ts, err := parseTime(field, timeFormat)
+			if err != nil || ts <= 0 {
+				return nil, errors.New(fmt.Sprintf("invalid %v: %v", column, field))
+			}
+			values[i] = ts
+			continue
+		}
+
+		number, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid %v: %v", column, field))
+		}
+		values[i] = number
+		numbers[column] = number
+	}
+
+	if target.validate != nil {
+		err := target.validate(numbers)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
+
+// insert saves the batch in a transaction, nothing of the batch is saved on error. The busy db is retried a few times
+func insert(db *sql.DB, target Target, batch []row, report *Report) error {
+	delay := retryDelay
+	for attempt := 0; ; attempt++ {
+		err := insertBatch(db, target, batch, report)
+		if err == nil || !util.IsBusy(err) || attempt >= busyRetries {
+			return err
+		}
+
+		log.WithFields(log.Fields{"table": target.Table, "rows": len(batch), "attempt": attempt + 1}).WithError(err).Warn("db busy, retry")
+
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+func insertBatch(db *sql.DB, target Target, batch []row, report *Report) error {
+	logger := log.WithField("table", target.Table)
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(target.Columns)), ",")
+	saveSql := fmt.Sprintf("INSERT OR IGNORE INTO `%v`(`%v`) VALUES(%v)", target.Table, strings.Join(target.Columns, "`,`"), placeholders)
+
+	tx, err := db.Begin()
+	if err != nil {
+		logger.WithError(err).Error("begin tx error")
+		return err
+	}
+
+	stmt, err := tx.Prepare(saveSql)
+	if err != nil {
+		logger.WithError(err).Error("prepare stmt error")
+		tx.Rollback()
+		return err
+	}
+
+	defer stmt.Close()
+
+	var inserted int64
+	var duplicates []int64
+	for _, v := range batch {
+		res, err := stmt.Exec(v.values...)
+		if err != nil {
+			if util.IsBusy(err) {
+				logger.WithField("line", v.line).WithError(err).Debug("exec save sql error")
+			} else {
+				logger.WithField("line", v.line).WithError(err).Error("exec save sql error")
+			}
+			tx.Rollback()
+			return err
+		}
+
+		affectedRows, err := res.RowsAffected()
+		if err != nil {
+			logger.WithError(err).Error("read affected rows error")
+			tx.Rollback()
+			return err
+		}
+
+		if affectedRows > 0 {
+			inserted++
+		} else {
+			duplicates = append(duplicates, v.line)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logger.WithError(err).Error("commit tx error")
+		return err
+	}
+
+	report.Inserted += inserted
+	for _, line := range duplicates {
+		report.duplicate(line)
+	}
+
+	logger.WithFields(log.Fields{"inserted": report.Inserted, "duplicates": report.Duplicates}).Debug("imported batch")
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package importer
+
+import (
+	"brti"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"util"
+)
+
+func openTestDB(t *testing.T) (*sql.DB, string) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := util.OpenDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	brti.InitDb(db)
+
+	return db, dbPath
+}
+
+func TestImport(t *testing.T) {
+	db, _ := openTestDB(t)
+
+	_, err := db.Exec("INSERT INTO `brti_logs`(`log_time`,`log_price`) VALUES(100,1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the quoted note spans two lines, the line numbers after it still match the file
+	csv := strings.Join([]string{
+		"time,price,note",
+		"100,1,stored",
+		"101,2,\"two",
+		"lines\"",
+		"102,-1,negative",
+		"103,3\"x,bare quote",
+		"104,4,ok",
+	}, "\n")
+
+	report, err := Import(db, strings.NewReader(csv), Targets["brti_logs"], Options{Mapping: map[string]string{"log_time": "time", "log_price": "price"}, BatchSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Rows != 5 || report.Inserted != 2 || report.Duplicates != 1 || report.Rejected != 2 {
+		t.Errorf("Import() = %+v, want 5 rows, 2 inserted, 1 duplicate and 2 rejected", report)
+	}
+	if len(report.DuplicateLines) != 1 || report.DuplicateLines[0] != 2 {
+		t.Errorf("duplicate lines %v, want [2]", report.DuplicateLines)
+	}
+	if len(report.Rejects) != 2 || report.Rejects[0].Line != 5 || report.Rejects[1].Line != 6 {
+		t.Errorf("rejects %+v, want lines 5 and 6", report.Rejects)
+	}
+}
+
+func TestImportRetriesBusyDB(t *testing.T) {
+	db, dbPath := openTestDB(t)
+
+	// another connection holds the write lock for a while
+	other, err := util.OpenDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	tx, err := other.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tx.Exec("INSERT INTO `brti_logs`(`log_time`,`log_price`) VALUES(1,1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		time.Sleep(time.Millisecond * 300)
+		tx.Commit()
+	}()
+
+	report, err := Import(db, strings.NewReader("log_time,log_price\n100,1\n"), Targets["brti_logs"], Options{})
+	if err != nil {
+		t.Fatalf("Import() = %v, the busy db should be retried", err)
+	}
+	if report.Inserted != 1 {
+		t.Errorf("%v rows inserted, want 1", report.Inserted)
+	}
+}
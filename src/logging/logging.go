@@ -0,0 +1,89 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	FormatText = "text"
+	FormatJson = "json"
+)
+
+// Configure sets the level and format of the standard logger
+func Configure(level string, format string) error {
+	lvl, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case FormatText:
+		log.SetFormatter(&log.TextFormatter{FullTimestamp: true})
+	case FormatJson:
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return errors.New(fmt.Sprintf("unknown log format: %v", format))
+	}
+
+	log.SetLevel(lvl)
+
+	return nil
+}
+
+// Sampler lets one of every Every logs through, the first one included, to keep the high frequency logs readable
+type Sampler struct {
+	every uint64
+	count uint64
+}
+
+// NewSampler lets every log through when every is below 2
+func NewSampler(every uint64) *Sampler {
+	return &Sampler{every: every}
+}
+
+// SetEvery changes the sampling while logs are being sampled
+func (s *Sampler) SetEvery(every uint64) {
+	atomic.StoreUint64(&s.every, every)
+}
+
+func (s *Sampler) Allow() bool {
+	every := atomic.LoadUint64(&s.every)
+	if every < 2 {
+		return true
+	}
+	return (atomic.AddUint64(&s.count, 1)-1)%every == 0
+}
+
+// Middleware logs the requests with their route, status and duration
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		entry := log.WithFields(log.Fields{
+			"method": c.Request.Method,
+			"path": c.Request.URL.Path,
+			"route": c.FullPath(),
+			"status": c.Writer.Status(),
+			"duration": time.Since(start).Seconds(),
+			"client": c.ClientIP(),
+		})
+
+		if len(c.Errors) > 0 {
+			entry = entry.WithField("error", c.Errors.String())
+		}
+
+		if c.Writer.Status() >= 500 {
+			entry.Error("request")
+		} else {
+			entry.Info("request")
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"alert"
+	"bus"
+	"metrics"
+	"net/http"
+	"strconv"
+	"time"
+	"util"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// alertBuffer is the number of ticks waiting to be evaluated before new ones are dropped
+const alertBuffer = 4096
+
+func startAlertEngine(dbPath string, rules []alert.Rule, eventBus *bus.Bus) *alert.Engine {
+	db, err := util.OpenDB(dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	engine := alert.NewEngine(db, eventBus)
+
+	err = engine.Load(alert.OriginConfig, rules)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	apiRules, err := alert.FindRules(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = engine.Load(alert.OriginApi, apiRules)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	subscription := eventBus.Subscribe("alert", alertBuffer, []string{bus.TypeTick}, []string{bus.AllChannels})
+
+	go engine.Run(subscription)
+
+	return engine
+}
+
+// startDivergenceMonitor returns nil when the monitor is disabled
+func startDivergenceMonitor(dbPath string, config alert.DivergenceConfig, eventBus *bus.Bus) *alert.DivergenceMonitor {
+	if !config.Enabled {
+		return nil
+	}
+
+	db, err := util.OpenDB(dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	monitor, err := alert.NewDivergenceMonitor(db, eventBus, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	subscription := eventBus.Subscribe("divergence", alertBuffer, []string{bus.TypeTick}, monitor.Channels())
+
+	go monitor.Run(subscription)
+
+	return monitor
+}
+
+func registerAlertRoutes(r *gin.Engine, dbPath string, engine *alert.Engine, monitor *alert.DivergenceMonitor) {
+	r.GET("/alerts/rules", func(c *gin.Context) {
+		c.JSON(http.StatusOK, engine.Rules())
+	})
+
+	r.POST("/alerts/rules", func(c *gin.Context) {
+		var rule alert.Rule
+
+		err := c.BindJSON(&rule)
+		if err != nil {
+			return
+		}
+
+		err = rule.Validate()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+
+		err = engine.Add(rule)
+		if err != nil {
+			log.WithError(err).Error("add alert rule error")
+			c.JSON(http.StatusConflict, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusCreated, rule)
+	})
+
+	r.DELETE("/alerts/rules/:name", func(c *gin.Context) {
+		found, err := engine.Remove(c.Param("name"))
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "not found",
+			})
+			return
+		}
+
+		if err != nil {
+			log.WithError(err).Error("remove alert rule error")
+			c.JSON(http.StatusConflict, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "deleted",
+		})
+	})
+
+	r.GET("/alerts/divergence", func(c *gin.Context) {
+		if monitor == nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "divergence monitor disabled",
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, monitor.State())
+	})
+
+	r.GET("/alerts/history", func(c *gin.Context) {
+		count, err := strconv.ParseInt(c.DefaultQuery("limit", "100"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "invalid limit",
+			})
+			return
+		}
+
+		db, err := util.OpenDB(dbPath)
+		if err != nil {
+			log.WithError(err).Error("open db error")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "internal server error",
+			})
+			return
+		}
+
+		defer db.Close()
+
+		queryStart := time.Now()
+		result, err := alert.FindHistory(db, int32(count))
+		metrics.ObserveQuery(metrics.Route(c), queryStart)
+		if err != nil {
+			log.WithError(err).Error("read alert history error")
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "error",
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	})
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"analytics"
+	"candle"
+	"database/sql"
+	"fmt"
+	"metrics"
+	"net/http"
+	"tick"
+	"time"
+	"util"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func startVolatilityTrackers(dbPath string, window int64, sampling int64) map[tick.Table]*analytics.VolatilityTracker {
+	trackers := make(map[tick.Table]*analytics.VolatilityTracker)
+	for _, table := range tick.Tables {
+		trackers[table] = analytics.NewVolatilityTracker(table, window, sampling)
+	}
+
+	go func() {
+		for {
+			func() {
+				db, err := util.OpenDB(dbPath)
+				if err != nil {
+					log.WithError(err).Error("open db error")
+					return
+				}
+				defer db.Close()
+
+				for _, tracker := range trackers {
+					tracker.Update(db)
+				}
+			}()
+
+			time.Sleep(time.Second * 10)
+		}
+	}()
+
+	return trackers
+}
+
+func registerAnalyticsRoutes(r *gin.Engine, dbPath string, trackers map[tick.Table]*analytics.VolatilityTracker) {
+	for _, v := range tick.Tables {
+		table := v
+
+		r.GET(fmt.Sprintf("/%v/%v/volatility", table.Source, table.Product), func(c *gin.Context) {
+			c.JSON(http.StatusOK, trackers[table].Snapshot())
+		})
+
+		r.GET(fmt.Sprintf("/%v/%v/volatility/:start/:end", table.Source, table.Product), func(c *gin.Context) {
+			sampling, ok := parseSampling(c)
+			if !ok {
+				return
+			}
+
+			serveAnalytics(c, dbPath, func(db *sql.DB, tsStart int64, tsEnd int64) (interface{}, error) {
+				return analytics.RealizedVolatility(db, table, tsStart, tsEnd, sampling)
+			})
+		})
+
+		r.GET(fmt.Sprintf("/%v/%v/returns/:start/:end", table.Source, table.Product), func(c *gin.Context) {
+			sampling, ok := parseSampling(c)
+			if !ok {
+				return
+			}
+
+			serveAnalytics(c, dbPath, func(db *sql.DB, tsStart int64, tsEnd int64) (interface{}, error) {
+				return analytics.Returns(db, table, tsStart, tsEnd, sampling)
+			})
+		})
+
+		r.GET(fmt.Sprintf("/%v/%v/drawdown/:start/:end", table.Source, table.Product), func(c *gin.Context) {
+			serveAnalytics(c, dbPath, func(db *sql.DB, tsStart int64, tsEnd int64) (interface{}, error) {
+				return analytics.FindDrawdown(db, table, tsStart, tsEnd)
+			})
+		})
+
+		r.GET(fmt.Sprintf("/%v/%v/vwap/:start/:end", table.Source, table.Product), func(c *gin.Context) {
+			err := analytics.CheckVolume([]tick.Table{table})
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": err.Error(),
+				})
+				return
+			}
+
+			serveAnalytics(c, dbPath, func(db *sql.DB, tsStart int64, tsEnd int64) (interface{}, error) {
+				return analytics.VWAP(db, table, tsStart, tsEnd)
+			})
+		})
+
+		r.GET(fmt.Sprintf("/%v/%v/twap/:start/:end", table.Source, table.Product), func(c *gin.Context) {
+			serveAnalytics(c, dbPath, func(db *sql.DB, tsStart int64, tsEnd int64) (interface{}, error) {
+				return analytics.TWAP(db, table, tsStart, tsEnd)
+			})
+		})
+	}
+
+	r.GET("/vwap/:start/:end", func(c *gin.Context) {
+		tables, ok := parseSources(c, "gdax")
+		if !ok {
+			return
+		}
+
+		err := analytics.CheckVolume(tables)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+
+		serveAnalytics(c, dbPath, func(db *sql.DB, tsStart int64, tsEnd int64) (interface{}, error) {
+			return analytics.CompositeVWAP(db, tables, tsStart, tsEnd)
+		})
+	})
+
+	r.GET("/twap/:start/:end", func(c *gin.Context) {
+		tables, ok := parseSources(c, "brti,gdax,bitstamp")
+		if !ok {
+			return
+		}
+
+		serveAnalytics(c, dbPath, func(db *sql.DB, tsStart int64, tsEnd int64) (interface{}, error) {
+			return analytics.CompositeTWAP(db, tables, tsStart, tsEnd)
+		})
+	})
+}
+
+// parseSampling reads the sampling query and checks the sample count of the :start and :end range
+func parseSampling(c *gin.Context) (int64, bool) {
+	sampling, err := candle.ParseInterval(c.DefaultQuery("sampling", "1m"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return 0, false
+	}
+
+	tsStart, tsEnd, ok := parseRange(c)
+	if !ok {
+		return 0, false
+	}
+
+	err = analytics.CheckReturnsRange(tsStart, tsEnd, sampling)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return 0, false
+	}
+
+	return sampling, true
+}
+
+// serveAnalytics serves the analytics of the :start and :end range, responds not found when there is no data
+func serveAnalytics(c *gin.Context, dbPath string, find func(db *sql.DB, tsStart int64, tsEnd int64) (interface{}, error)) {
+	tsStart, tsEnd, ok := parseRange(c)
+	if !ok {
+		return
+	}
+
+	db, err := util.OpenDB(dbPath)
+	if err != nil {
+		log.WithError(err).Error("open db error")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "internal server error",
+		})
+		return
+	}
+
+	defer db.Close()
+
+	queryStart := time.Now()
+	result, err := find(db, tsStart, tsEnd)
+	metrics.ObserveQuery(metrics.Route(c), queryStart)
+	if err == analytics.ErrNoData {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "not found",
+		})
+		return
+	}
+
+	if err != nil {
+		log.WithError(err).Error("read average price error")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "internal server error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bus"
+	"candle"
+	"fmt"
+	"metrics"
+	"net/http"
+	"strconv"
+	"sync"
+	"tick"
+	"time"
+	"util"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultCandleCount is the number of candles returned when start is not given
+const defaultCandleCount = 100
+
+func parseCandleIntervals(intervals []string) ([]int64, error) {
+	var result []int64
+	for _, v := range intervals {
+		interval, err := candle.ParseInterval(v)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, interval)
+	}
+	return result, nil
+}
+
+// intervalSet is the candle intervals to materialize, they can be changed while the materializer runs
+type intervalSet struct {
+	mutex sync.RWMutex
+	intervals []int64
+}
+
+func newIntervalSet(intervals []int64) *intervalSet {
+	return &intervalSet{intervals: intervals}
+}
+
+func (s *intervalSet) Get() []int64 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.intervals
+}
+
+func (s *intervalSet) Set(intervals []int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.intervals = intervals
+}
+
+func startCandleMaterializer(dbPath string, intervals *intervalSet, eventBus *bus.Bus) {
+	go func() {
+		// backfill the history of each interval once when it is added, then only keep the latest candles up to date
+		backfilled := make(map[int64]bool)
+
+		for {
+			func() {
+				db, err := util.OpenDB(dbPath)
+				if err != nil {
+					log.WithError(err).Error("open db error")
+					return
+				}
+				defer db.Close()
+
+				now := time.Now().Unix()
+
+				current := intervals.Get()
+
+				for _, table := range tick.Tables {
+					for _, interval := range current {
+						backfill := !backfilled[interval]
+
+						tsStart := candle.BucketStart(now, interval) - interval
+						if backfill {
+							tsStart = candle.BucketStart(now, interval) - interval*(candle.MaxCandles-1)
+						}
+
+						candles, err := candle.Materialize(db, table, interval, tsStart, now)
+						if err != nil {
+							log.WithFields(log.Fields{"source": table.Source, "product": table.Product}).WithError(err).Error("materialize candles error")
+							continue
+						}
+
+						if backfill {
+							continue
+						}
+
+						for i := range candles {
+							eventBus.Publish(bus.Event{
+								Type: bus.TypeCandle,
+								Source: table.Source,
+								Product: table.Product,
+								Timestamp: candles[i].Time,
+								Interval: interval,
+								Candle: &candles[i],
+							})
+						}
+					}
+				}
+
+				// forget the removed intervals so they are backfilled again when added back
+				backfilled = make(map[int64]bool)
+				for _, interval := range current {
+					backfilled[interval] = true
+				}
+			}()
+
+			time.Sleep(time.Second * 10)
+		}
+	}()
+}
+
+func registerCandleRoutes(r *gin.Engine, dbPath string, materialized *intervalSet) {
+	for _, v := range tick.Tables {
+		table := v
+
+		r.GET(fmt.Sprintf("/%v/%v/candles", table.Source, table.Product), func(c *gin.Context) {
+			interval, err := candle.ParseInterval(c.DefaultQuery("interval", "1m"))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": err.Error(),
+				})
+				return
+			}
+
+			tsEnd := time.Now().Unix()
+			if c.Query("end") != "" {
+				tsEnd, err = strconv.ParseInt(c.Query("end"), 10, 64)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{
+						"message": "invalid end",
+					})
+					return
+				}
+			}
+
+			tsStart := tsEnd - interval*(defaultCandleCount-1)
+			if c.Query("start") != "" {
+				tsStart, err = strconv.ParseInt(c.Query("start"), 10, 64)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{
+						"message": "invalid start",
+					})
+					return
+				}
+			}
+
+			err = candle.CheckRange(interval, tsStart, tsEnd)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": err.Error(),
+				})
+				return
+			}
+
+			db, err := util.OpenDB(dbPath)
+			if err != nil {
+				log.WithError(err).Error("open db error")
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"message": "internal server error",
+				})
+				return
+			}
+
+			defer db.Close()
+
+			var result []candle.Candle
+			queryStart := time.Now()
+			if containsInterval(materialized.Get(), interval) {
+				result, err = candle.FindMaterialized(db, table, interval, tsStart, tsEnd)
+			} else {
+				result, err = candle.Resample(db, table, interval, tsStart, tsEnd)
+			}
+			metrics.ObserveQuery(metrics.Route(c), queryStart)
+
+			if err != nil {
+				log.WithFields(log.Fields{"source": table.Source, "product": table.Product}).WithError(err).Error("read candles error")
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"message": "internal server error",
+				})
+				return
+			}
+
+			c.JSON(http.StatusOK, result)
+		})
+	}
+}
+
+func containsInterval(intervals []int64, interval int64) bool {
+	for _, v := range intervals {
+		if v == interval {
+			return true
+		}
+	}
+	return false
+}
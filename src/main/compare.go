@@ -0,0 +1,91 @@
+package main
+
+import (
+	"compare"
+	"metrics"
+	"net/http"
+	"strconv"
+	"time"
+	"util"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func registerCompareRoutes(r *gin.Engine, dbPath string) {
+	r.GET("/compare", func(c *gin.Context) {
+		tables, ok := parseSources(c, "brti,gdax,bitstamp")
+		if !ok {
+			return
+		}
+
+		if len(tables) < 2 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "at least two sources are required",
+			})
+			return
+		}
+
+		step, err := strconv.ParseInt(c.DefaultQuery("step", "60"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "invalid step",
+			})
+			return
+		}
+
+		tsEnd := time.Now().Unix()
+		if c.Query("end") != "" {
+			tsEnd, err = strconv.ParseInt(c.Query("end"), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": "invalid end",
+				})
+				return
+			}
+		}
+
+		tsStart := tsEnd - 3600
+		if c.Query("start") != "" {
+			tsStart, err = strconv.ParseInt(c.Query("start"), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": "invalid start",
+				})
+				return
+			}
+		}
+
+		err = compare.CheckRange(tsStart, tsEnd, step)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+
+		db, err := util.OpenDB(dbPath)
+		if err != nil {
+			log.WithError(err).Error("open db error")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "internal server error",
+			})
+			return
+		}
+
+		defer db.Close()
+
+		queryStart := time.Now()
+		result, err := compare.Compare(db, tables, tsStart, tsEnd, step)
+		metrics.ObserveQuery(metrics.Route(c), queryStart)
+		if err != nil {
+			log.WithError(err).Error("compare sources error")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "internal server error",
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	})
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"alert"
+	"batch"
+	"bus"
+	"candle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"health"
+	"io"
+	"logging"
+	"net/url"
+	"os"
+	"retention"
+	"sink"
+	"strings"
+	"tick"
+	"time"
+	"tracing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+type FetcherConfig struct {
+	Port int64
+	FetchBRTI bool
+	FetchBitstamp bool
+	FetchGdax bool
+	BrtiInterval string
+	BitstampInterval string
+	GdaxInterval string
+	// Products are the products fetched of each source, a product is only fetched when it has a tick table
+	Products map[string][]string
+	CandleIntervals []string
+	VolatilityWindow string
+	VolatilitySampling string
+	Sink sink.Config
+	MQTT sink.MQTTConfig
+	Alerts []alert.Rule
+	Divergence alert.DivergenceConfig
+	SLA []health.SLA
+	Log LogConfig
+	Tracing tracing.Config
+	DB DBConfig
+	Retention retention.Config
+	Storage batch.Config
+}
+
+// DBConfig locates the sqlite db by its path or DSN, the sources in Sources write their ticks to their own db files
+// instead to reduce the lock contention, the files are attached to every connection by the source names
+// and keep the rollback journal instead of WAL so a transaction over several files is committed atomically
+type DBConfig struct {
+	Path string
+	Sources map[string]string
+}
+
+// LogConfig sets the logger, BrtiSampling logs one of every BrtiSampling successful BRTI fetches, the failed ones are all logged
+type LogConfig struct {
+	Level string
+	Format string
+	BrtiSampling uint64
+}
+
+// envPrefix is the prefix of the environment variables overriding the config, like FETCHER_PORT or FETCHER_SINK_TYPE
+const envPrefix = "FETCHER"
+
+// initConfig reads the config file next to the binary in dataPath unless configFile is given,
+// the environment variables override the config file which overrides the defaults
+func initConfig(dataPath string, configFile string) (FetcherConfig, error) {
+	viper.SetDefault("Port", 8080)
+	viper.SetDefault("FetchBRTI", false)
+	viper.SetDefault("FetchBitstamp", true)
+	viper.SetDefault("FetchGdax", true)
+	viper.SetDefault("BrtiInterval", "500ms")
+	viper.SetDefault("BitstampInterval", "10s")
+	viper.SetDefault("GdaxInterval", "10s")
+	viper.SetDefault("Products", map[string][]string{
+		"brti": {"btcusd"},
+		"bitstamp": {"btcusd"},
+		"gdax": {"btcusd"},
+	})
+	viper.SetDefault("CandleIntervals", []string{"1m", "1h"})
+	viper.SetDefault("VolatilityWindow", "1h")
+	viper.SetDefault("VolatilitySampling", "1m")
+	viper.SetDefault("Log.Level", "info")
+	viper.SetDefault("Log.Format", "text")
+	viper.SetDefault("Log.BrtiSampling", 100)
+	viper.SetDefault("Tracing.Exporter", "")
+	viper.SetDefault("Tracing.Endpoint", "localhost:4318")
+	viper.SetDefault("Tracing.Insecure", true)
+	viper.SetDefault("Tracing.ServiceName", "cme-brti-fetcher")
+	viper.SetDefault("Tracing.SampleRatio", 1)
+	viper.SetDefault("DB.Path", fmt.Sprintf("%v/brti.db", dataPath))
+	viper.SetDefault("Storage.Size", 500)
+	viper.SetDefault("Storage.FlushInterval", "1s")
+	viper.SetDefault("Storage.QueueSize", 4096)
+	viper.SetDefault("Storage.Retries", 5)
+	viper.SetDefault("Storage.RetryDelay", "100ms")
+	viper.SetDefault("Storage.MaxRetryDelay", "5s")
+	viper.SetDefault("Retention.Enabled", false)
+	viper.SetDefault("Retention.Interval", "1h")
+	viper.SetDefault("Retention.BatchSize", 5000)
+	viper.SetDefault("Retention.DryRun", false)
+	viper.SetDefault("Retention.Policies", []map[string]interface{}{
+		{"Source": "brti", "Product": "btcusd", "KeepDays": 30, "Rollups": []string{"1m", "1h"}},
+		{"Source": "bitstamp", "Product": "btcusd", "KeepDays": 90, "Rollups": []string{"1m", "1h"}},
+		{"Source": "gdax", "Product": "btcusd", "KeepDays": 90, "Rollups": []string{"1m", "1h"}},
+	})
+	viper.SetDefault("Sink.Type", "")
+	viper.SetDefault("Sink.URL", "nats://127.0.0.1:4222")
+	viper.SetDefault("Sink.Brokers", []string{"127.0.0.1:9092"})
+	viper.SetDefault("Sink.SubjectTemplate", "prices.{source}.{product}")
+	viper.SetDefault("Sink.SpoolPath", fmt.Sprintf("%v/sink.spool", dataPath))
+	viper.SetDefault("Sink.SpoolMaxBytes", 100*1024*1024)
+	viper.SetDefault("Sink.Retries", 3)
+	viper.SetDefault("MQTT.Broker", "")
+	viper.SetDefault("MQTT.ClientID", "cme-brti-fetcher")
+	viper.SetDefault("MQTT.QoS", 1)
+	viper.SetDefault("MQTT.TopicTemplate", "prices/{source}/{product}")
+	viper.SetDefault("MQTT.Topics", map[string]string{
+		"brti/btcusd": "prices/brti",
+		"gdax/btcusd": "prices/gdax/BTC-USD",
+		"bitstamp/btcusd": "prices/bitstamp/btcusd",
+	})
+
+	viper.SetDefault("Divergence.Enabled", true)
+	viper.SetDefault("Divergence.Name", "divergence")
+	viper.SetDefault("Divergence.Product", "btcusd")
+	viper.SetDefault("Divergence.Reference", "brti")
+	viper.SetDefault("Divergence.Sources", []string{"gdax", "bitstamp"})
+	viper.SetDefault("Divergence.Threshold", 50)
+	viper.SetDefault("Divergence.Window", 60)
+	viper.SetDefault("Divergence.MaxAge", 60)
+	viper.SetDefault("Divergence.Cooldown", 600)
+	viper.SetDefault("SLA", []map[string]interface{}{
+		{"Source": "brti", "Product": "btcusd", "MaxFetchAge": 10, "MaxTickAge": 30, "Critical": true},
+		{"Source": "bitstamp", "Product": "btcusd", "MaxFetchAge": 60, "MaxTickAge": 300, "Critical": false},
+		{"Source": "gdax", "Product": "btcusd", "MaxFetchAge": 60, "MaxTickAge": 300, "Critical": false},
+	})
+
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+
+	if configFile != "" {
+		viper.SetConfigFile(configFile)
+	} else {
+		viper.SetConfigName("config")
+		viper.AddConfigPath(dataPath)
+	}
+
+	err := viper.ReadInConfig()
+	if err != nil {
+		switch err.(type) {
+		case viper.ConfigFileNotFoundError:
+			log.WithError(err).Warn("config not found, use defaults")
+			break
+		default:
+			log.WithError(err).Error("read config error")
+			return FetcherConfig{}, err
+		}
+	}
+
+	return readConfig()
+}
+
+// readConfig reads the config from the values currently loaded by viper
+func readConfig() (FetcherConfig, error) {
+	var config FetcherConfig
+
+	config.Log.Level = viper.GetString("Log.Level")
+	config.Log.Format = viper.GetString("Log.Format")
+	config.Log.BrtiSampling = uint64(viper.GetInt64("Log.BrtiSampling"))
+	config.Tracing.Exporter = viper.GetString("Tracing.Exporter")
+	config.Tracing.Endpoint = viper.GetString("Tracing.Endpoint")
+	config.Tracing.Insecure = viper.GetBool("Tracing.Insecure")
+	config.Tracing.Headers = viper.GetStringMapString("Tracing.Headers")
+	config.Tracing.ServiceName = viper.GetString("Tracing.ServiceName")
+	config.Tracing.SampleRatio = viper.GetFloat64("Tracing.SampleRatio")
+	config.DB.Path = viper.GetString("DB.Path")
+	config.DB.Sources = viper.GetStringMapString("DB.Sources")
+	config.Storage.Size = viper.GetInt("Storage.Size")
+	config.Storage.FlushInterval = viper.GetString("Storage.FlushInterval")
+	config.Storage.QueueSize = viper.GetInt("Storage.QueueSize")
+	config.Storage.Retries = viper.GetInt("Storage.Retries")
+	config.Storage.RetryDelay = viper.GetString("Storage.RetryDelay")
+	config.Storage.MaxRetryDelay = viper.GetString("Storage.MaxRetryDelay")
+	config.Retention.Enabled = viper.GetBool("Retention.Enabled")
+	config.Retention.Interval = viper.GetString("Retention.Interval")
+	config.Retention.BatchSize = viper.GetInt64("Retention.BatchSize")
+	config.Retention.DryRun = viper.GetBool("Retention.DryRun")
+	config.Port = viper.GetInt64("Port")
+	config.FetchBRTI = viper.GetBool("FetchBRTI")
+	config.FetchBitstamp = viper.GetBool("FetchBitstamp")
+	config.FetchGdax = viper.GetBool("FetchGdax")
+	config.BrtiInterval = viper.GetString("BrtiInterval")
+	config.BitstampInterval = viper.GetString("BitstampInterval")
+	config.GdaxInterval = viper.GetString("GdaxInterval")
+	config.Products = viper.GetStringMapStringSlice("Products")
+	config.CandleIntervals = viper.GetStringSlice("CandleIntervals")
+	config.VolatilityWindow = viper.GetString("VolatilityWindow")
+	config.VolatilitySampling = viper.GetString("VolatilitySampling")
+	config.Sink.Type = viper.GetString("Sink.Type")
+	config.Sink.URL = viper.GetString("Sink.URL")
+	config.Sink.Brokers = viper.GetStringSlice("Sink.Brokers")
+	config.Sink.SubjectTemplate = viper.GetString("Sink.SubjectTemplate")
+	config.Sink.Subjects = viper.GetStringMapString("Sink.Subjects")
+	config.Sink.SpoolPath = viper.GetString("Sink.SpoolPath")
+	config.Sink.SpoolMaxBytes = viper.GetInt64("Sink.SpoolMaxBytes")
+	config.Sink.Retries = viper.GetInt("Sink.Retries")
+	config.MQTT.Broker = viper.GetString("MQTT.Broker")
+	config.MQTT.ClientID = viper.GetString("MQTT.ClientID")
+	config.MQTT.Username = viper.GetString("MQTT.Username")
+	config.MQTT.Password = viper.GetString("MQTT.Password")
+	config.MQTT.QoS = byte(viper.GetInt("MQTT.QoS"))
+	config.MQTT.TopicTemplate = viper.GetString("MQTT.TopicTemplate")
+	config.MQTT.Topics = viper.GetStringMapString("MQTT.Topics")
+	config.MQTT.CAFile = viper.GetString("MQTT.CAFile")
+	config.MQTT.CertFile = viper.GetString("MQTT.CertFile")
+	config.MQTT.KeyFile = viper.GetString("MQTT.KeyFile")
+	config.MQTT.InsecureSkipVerify = viper.GetBool("MQTT.InsecureSkipVerify")
+
+	config.Divergence.Enabled = viper.GetBool("Divergence.Enabled")
+	config.Divergence.Name = viper.GetString("Divergence.Name")
+	config.Divergence.Product = viper.GetString("Divergence.Product")
+	config.Divergence.Reference = viper.GetString("Divergence.Reference")
+	config.Divergence.Sources = viper.GetStringSlice("Divergence.Sources")
+	config.Divergence.Threshold = viper.GetFloat64("Divergence.Threshold")
+	config.Divergence.Window = viper.GetInt64("Divergence.Window")
+	config.Divergence.MaxAge = viper.GetInt64("Divergence.MaxAge")
+	config.Divergence.Cooldown = viper.GetInt64("Divergence.Cooldown")
+	config.Divergence.Webhook = viper.GetString("Divergence.Webhook")
+
+	err := viper.UnmarshalKey("Alerts", &config.Alerts)
+	if err != nil {
+		log.WithError(err).Error("read alerts config error")
+		return config, err
+	}
+
+	err = viper.UnmarshalKey("Retention.Policies", &config.Retention.Policies)
+	if err != nil {
+		log.WithError(err).Error("read retention config error")
+		return config, err
+	}
+
+	err = viper.UnmarshalKey("SLA", &config.SLA)
+	if err != nil {
+		log.WithError(err).Error("read sla config error")
+		return config, err
+	}
+
+	return config, nil
+}
+
+
+// Validate lists all the problems of the config at once
+func (c FetcherConfig) Validate() error {
+	var problems []string
+
+	if c.Port < 1 || c.Port > 65535 {
+		problems = append(problems, "Port should be between 1 and 65535")
+	}
+
+	for _, v := range []struct {
+		name string
+		interval string
+	}{
+		{"BrtiInterval", c.BrtiInterval},
+		{"BitstampInterval", c.BitstampInterval},
+		{"GdaxInterval", c.GdaxInterval},
+	} {
+		interval, err := time.ParseDuration(v.interval)
+		if err != nil || interval <= 0 {
+			problems = append(problems, fmt.Sprintf("%v should be a positive duration like 10s", v.name))
+		}
+	}
+
+	for source, products := range c.Products {
+		for _, product := range products {
+			_, err := tick.FindTable(source, product)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("Products: %v", err))
+			} else if source == tick.SourceGdax && gdaxProducts[product] == "" {
+				problems = append(problems, fmt.Sprintf("Products: no gdax product id for %v", product))
+			}
+		}
+	}
+
+	_, err := parseCandleIntervals(c.CandleIntervals)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("CandleIntervals: %v", err))
+	}
+
+	volatilityWindow, err := candle.ParseInterval(c.VolatilityWindow)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("VolatilityWindow: %v", err))
+	}
+
+	volatilitySampling, err := candle.ParseInterval(c.VolatilitySampling)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("VolatilitySampling: %v", err))
+	}
+
+	if volatilityWindow > 0 && volatilitySampling > 0 && volatilitySampling >= volatilityWindow {
+		problems = append(problems, "VolatilitySampling should be shorter than VolatilityWindow")
+	}
+
+	_, err = log.ParseLevel(c.Log.Level)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("Log.Level: %v", err))
+	}
+
+	if c.Log.Format != logging.FormatText && c.Log.Format != logging.FormatJson {
+		problems = append(problems, fmt.Sprintf("Log.Format should be %v or %v", logging.FormatText, logging.FormatJson))
+	}
+
+	if c.Tracing.Exporter != tracing.ExporterNone && c.Tracing.Exporter != tracing.ExporterOtlp {
+		problems = append(problems, fmt.Sprintf("Tracing.Exporter should be empty or %v", tracing.ExporterOtlp))
+	}
+
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		problems = append(problems, "Tracing.SampleRatio should be between 0 and 1")
+	}
+
+	if c.Sink.Type != "" && c.Sink.Type != sink.TypeNats && c.Sink.Type != sink.TypeKafka {
+		problems = append(problems, fmt.Sprintf("Sink.Type should be empty, %v or %v", sink.TypeNats, sink.TypeKafka))
+	}
+
+	if c.Sink.Retries < 0 {
+		problems = append(problems, "Sink.Retries should not be negative")
+	}
+
+	if c.MQTT.QoS > 2 {
+		problems = append(problems, "MQTT.QoS should be 0, 1 or 2")
+	}
+
+	if c.Divergence.Enabled {
+		err = c.Divergence.Validate()
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, r := range c.Alerts {
+		err = r.Validate()
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+
+		if names[r.Name] {
+			problems = append(problems, fmt.Sprintf("duplicated rule: %v", r.Name))
+		}
+		names[r.Name] = true
+	}
+
+	channels := make(map[string]bool)
+	for _, s := range c.SLA {
+		err = s.Validate()
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+
+		channel := bus.Channel(s.Source, s.Product)
+		if channels[channel] {
+			problems = append(problems, fmt.Sprintf("duplicated sla: %v", channel))
+		}
+		channels[channel] = true
+	}
+
+	err = c.Storage.Validate()
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if c.Retention.Enabled {
+		err = c.Retention.Validate()
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if c.DB.Path == "" {
+		problems = append(problems, "DB.Path should not be empty")
+	}
+
+	files := map[string]bool{c.DB.Path: true}
+	for source, file := range c.DB.Sources {
+		if source != tick.SourceBrti && source != tick.SourceGdax && source != tick.SourceBitstamp {
+			problems = append(problems, fmt.Sprintf("DB.Sources: unknown source: %v", source))
+		}
+
+		if file == "" || files[file] {
+			problems = append(problems, fmt.Sprintf("DB.Sources: %v should have its own db file", source))
+		}
+		files[file] = true
+	}
+
+	if len(problems) > 0 {
+		return errors.New(fmt.Sprintf("invalid config:\n  %v", strings.Join(problems, "\n  ")))
+	}
+
+	return nil
+}
+
+// maskURL hides the password of the userinfo and the query values of the url, like the SASL parameters of a broker
+func maskURL(s string) string {
+	if s == "" {
+		return s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return "******"
+	}
+
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "******")
+		}
+	}
+
+	query := u.Query()
+	for k := range query {
+		query.Set(k, "******")
+	}
+	u.RawQuery = query.Encode()
+
+	// the mask is kept readable rather than escaped
+	return strings.NewReplacer("%2A", "*", "%2a", "*").Replace(u.String())
+}
+
+// masked hides the secrets of the config before it is printed
+func (c FetcherConfig) masked() FetcherConfig {
+	result := c
+
+	result.Sink.URL = maskURL(result.Sink.URL)
+
+	if result.MQTT.Password != "" {
+		result.MQTT.Password = "******"
+	}
+
+	headers := make(map[string]string)
+	for k := range result.Tracing.Headers {
+		headers[k] = "******"
+	}
+	result.Tracing.Headers = headers
+
+	return result
+}
+
+// printConfig prints the effective config with the secrets masked
+func printConfig(w io.Writer, config FetcherConfig) error {
+	body, err := json.MarshalIndent(config.masked(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(w, string(body))
+	return err
+}
+
+// runConfigCommand runs the config subcommand and returns the exit code,
+// config check prints the effective config merged from the defaults, the config file and the environment
+func runConfigCommand(dataPath string, args []string) int {
+	if len(args) < 1 || args[0] != "check" {
+		fmt.Fprintln(os.Stderr, "usage: config check [--config path]")
+		return 2
+	}
+
+	flags := flag.NewFlagSet("config check", flag.ExitOnError)
+	configFile := flags.String("config", "", "path of the config file, config.yaml next to the binary by default")
+	flags.Parse(args[1:])
+
+	config, err := initConfig(dataPath, *configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	err = printConfig(os.Stdout, config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	err = config.Validate()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Fprintln(os.Stderr, "config is valid")
+	return 0
+}
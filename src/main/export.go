@@ -0,0 +1,165 @@
+package main
+
+import (
+	"candle"
+	"export"
+	"flag"
+	"fmt"
+	"io"
+	"metrics"
+	"net/http"
+	"os"
+	"tick"
+	"time"
+	"util"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func registerExportRoutes(r *gin.Engine, dbPath string, materialized *intervalSet) {
+	r.GET("/export/:source/:product", func(c *gin.Context) {
+		table, err := tick.FindTable(c.Param("source"), c.Param("product"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+
+		tsStart, tsEnd, ok := parseQueryRange(c)
+		if !ok {
+			return
+		}
+
+		format := c.DefaultQuery("format", export.FormatCsv)
+		kind := c.DefaultQuery("kind", export.KindTicks)
+
+		err = export.Check(format, kind)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+
+		interval, err := candle.ParseInterval(c.DefaultQuery("interval", "1m"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+
+		db, err := util.OpenDB(dbPath)
+		if err != nil {
+			log.WithError(err).Error("open db error")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "internal server error",
+			})
+			return
+		}
+
+		defer db.Close()
+
+		c.Header("Content-Type", export.ContentTypes[format])
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%v_%v_%v_%v_%v.%v", table.Source, table.Product, kind, tsStart, tsEnd, format))
+		c.Status(http.StatusOK)
+
+		w, err := export.NewWriter(c.Writer, format, kind)
+		if err != nil {
+			log.WithFields(log.Fields{"source": table.Source, "product": table.Product}).WithError(err).Error("create export writer error")
+			return
+		}
+
+		// the status is sent with the first rows, an error in the middle can only cut the output short
+		queryStart := time.Now()
+		err = export.Export(db, w, table, kind, interval, containsInterval(materialized.Get(), interval), tsStart, tsEnd)
+		metrics.ObserveQuery(metrics.Route(c), queryStart)
+		if err != nil {
+			log.WithFields(log.Fields{"source": table.Source, "product": table.Product}).WithError(err).Error("export error")
+		}
+	})
+}
+
+// runExportCommand writes the ticks or candles of a source to a file or the stdout, it returns the exit code
+func runExportCommand(dataPath string, args []string) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	configFile := flags.String("config", "", "path of the config file, config.yaml next to the binary by default")
+	source := flags.String("source", tick.SourceBrti, "source to export, brti, gdax or bitstamp")
+	product := flags.String("product", tick.ProductBtcUsd, "product to export")
+	start := flags.Int64("start", 0, "start timestamp, the whole history by default")
+	end := flags.Int64("end", 0, "end timestamp, now by default")
+	format := flags.String("format", export.FormatCsv, "csv, parquet or ndjson")
+	kind := flags.String("kind", export.KindTicks, "ticks or candles")
+	intervalFlag := flags.String("interval", "1m", "candle interval like 1m or 1h")
+	output := flags.String("output", "", "output file, the stdout by default")
+	flags.Parse(args)
+
+	config, err := initConfig(dataPath, *configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	table, err := tick.FindTable(*source, *product)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	interval, err := candle.ParseInterval(*intervalFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	tsEnd := *end
+	if tsEnd == 0 {
+		tsEnd = time.Now().Unix()
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		defer file.Close()
+		out = file
+	}
+
+	w, err := export.NewWriter(out, *format, *kind)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	util.AttachDBs(config.DB.Sources)
+
+	err = checkAttachedDBs(config.DB.Path, config.DB.Sources)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	db, err := util.OpenDB(config.DB.Path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	defer db.Close()
+
+	materialized, _ := parseCandleIntervals(config.CandleIntervals)
+
+	err = export.Export(db, w, table, *kind, interval, containsInterval(materialized, interval), *start, tsEnd)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
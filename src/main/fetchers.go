@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bitstamp"
+	"brti"
+	"bus"
+	"candle"
+	"context"
+	"gdax"
+	"logging"
+	"metrics"
+	"sync"
+	"tick"
+	"time"
+	"tracing"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// gdaxProducts are the gdax product ids of the products, the other sources name them like the ticks
+var gdaxProducts = map[string]string{
+	tick.ProductBtcUsd: gdax.ProductBtcUsd,
+}
+
+func publishFetchError(eventBus *bus.Bus, source string, product string, err error) {
+	eventBus.Publish(bus.Event{
+		Type: bus.TypeFetchError,
+		Source: source,
+		Product: product,
+		Timestamp: time.Now().Unix(),
+		Error: err.Error(),
+	})
+}
+
+// publishFetched tells the fetch succeeded, timestamp and price are the ones of the tick fetched or 0 when the fetch has no tick
+func publishFetched(eventBus *bus.Bus, source string, product string, timestamp int64, price float64) {
+	eventBus.Publish(bus.Event{
+		Type: bus.TypeFetched,
+		Source: source,
+		Product: product,
+		Timestamp: timestamp,
+		Price: price,
+	})
+}
+
+// logFetch logs the result of a fetch, the errors are always logged while successful fetches sampled out by the sampler are not
+func logFetch(sampler *logging.Sampler, source string, product string, start time.Time, fields log.Fields, err error) {
+	if err == nil && sampler != nil && !sampler.Allow() {
+		return
+	}
+
+	entry := log.WithFields(log.Fields{
+		"source": source,
+		"product": product,
+		"duration": time.Since(start).Seconds(),
+	}).WithFields(fields)
+
+	if err != nil {
+		entry.WithError(err).Error("fetch error")
+	} else {
+		entry.Debug("fetched")
+	}
+}
+
+// startBrtiFetcher logs only the successful fetches let through by the sampler since the index is fetched several times a second, the errors are all logged
+func startBrtiFetcher(eventBus *bus.Bus, store *storage, sampler *logging.Sampler, product string, interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		for {
+			// the index is only updated every second, fetch concurrently to catch each print as early as possible
+			maxConcurrent := 3
+
+			for i := 0; i < maxConcurrent; i++ {
+				go func() {
+					ctx, span := tracing.Start(context.Background(), "fetch", attribute.String("source", tick.SourceBrti), attribute.String("product", product), attribute.String("kind", "ticker"))
+					start := time.Now()
+					ticker, err := brti.FetchTicker(ctx)
+					metrics.ObserveFetch(tick.SourceBrti, start, err)
+					tracing.End(span, err)
+					if err != nil {
+						logFetch(sampler, tick.SourceBrti, product, start, log.Fields{"kind": "ticker"}, err)
+						publishFetchError(eventBus, tick.SourceBrti, product, err)
+						return
+					}
+
+					logFetch(sampler, tick.SourceBrti, product, start, log.Fields{"kind": "ticker", "timestamp": ticker.Timestamp, "price": ticker.Price}, nil)
+					publishFetched(eventBus, tick.SourceBrti, product, ticker.Timestamp, ticker.Price)
+
+					store.Store(bus.Event{
+						Type: bus.TypeTick,
+						Source: tick.SourceBrti,
+						Product: product,
+						Timestamp: ticker.Timestamp,
+						Price: ticker.Price,
+						Record: ticker,
+						Context: ctx,
+					})
+				}()
+			}
+
+			select {
+			case <-stop:
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+}
+
+func startBitstampFetcher(eventBus *bus.Bus, store *storage, product string, interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		for {
+			go func() {
+				ctx, span := tracing.Start(context.Background(), "fetch", attribute.String("source", tick.SourceBitstamp), attribute.String("product", product), attribute.String("kind", "ticker"))
+				start := time.Now()
+				ticker, err := bitstamp.FetchTicker(ctx, product)
+				metrics.ObserveFetch(tick.SourceBitstamp, start, err)
+				tracing.End(span, err)
+				if err != nil {
+					logFetch(nil, tick.SourceBitstamp, product, start, log.Fields{"kind": "ticker"}, err)
+					publishFetchError(eventBus, tick.SourceBitstamp, product, err)
+					return
+				}
+
+				logFetch(nil, tick.SourceBitstamp, product, start, log.Fields{"kind": "ticker", "timestamp": ticker.Timestamp, "price": ticker.Price}, nil)
+				publishFetched(eventBus, tick.SourceBitstamp, product, ticker.Timestamp, ticker.Price)
+
+				store.Store(bus.Event{
+					Type: bus.TypeTick,
+					Source: tick.SourceBitstamp,
+					Product: product,
+					Timestamp: ticker.Timestamp,
+					Price: ticker.Price,
+					Record: ticker,
+					Context: ctx,
+				})
+			}()
+
+			select {
+			case <-stop:
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+}
+
+func startGdaxFetcher(eventBus *bus.Bus, store *storage, product string, interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		for {
+			go func() {
+				ctx, span := tracing.Start(context.Background(), "fetch", attribute.String("source", tick.SourceGdax), attribute.String("product", product), attribute.String("kind", "ticker"))
+				start := time.Now()
+				ticker, err := gdax.FetchTicker(ctx, gdaxProducts[product])
+				metrics.ObserveFetch(tick.SourceGdax, start, err)
+				tracing.End(span, err)
+				if err != nil {
+					logFetch(nil, tick.SourceGdax, product, start, log.Fields{"kind": "ticker"}, err)
+					publishFetchError(eventBus, tick.SourceGdax, product, err)
+					return
+				}
+
+				logFetch(nil, tick.SourceGdax, product, start, log.Fields{"kind": "ticker", "timestamp": ticker.Timestamp, "price": ticker.Price}, nil)
+				publishFetched(eventBus, tick.SourceGdax, product, ticker.Timestamp, ticker.Price)
+
+				store.Store(bus.Event{
+					Type: bus.TypeTick,
+					Source: tick.SourceGdax,
+					Product: product,
+					Timestamp: ticker.Timestamp,
+					Price: ticker.Price,
+					Record: ticker,
+					Context: ctx,
+				})
+			}()
+
+			go func() {
+				tsEnd := time.Now().Unix()
+
+				tsStart := tsEnd - 120
+
+				ctx, span := tracing.Start(context.Background(), "fetch", attribute.String("source", tick.SourceGdax), attribute.String("product", product), attribute.String("kind", "historic"))
+				start := time.Now()
+				historics, err := gdax.FetchHistoric(ctx, gdaxProducts[product], tsStart, tsEnd)
+				metrics.ObserveFetch(tick.SourceGdax, start, err)
+				tracing.End(span, err)
+				if err != nil {
+					logFetch(nil, tick.SourceGdax, product, start, log.Fields{"kind": "historic"}, err)
+					publishFetchError(eventBus, tick.SourceGdax, product, err)
+					return
+				}
+
+				logFetch(nil, tick.SourceGdax, product, start, log.Fields{"kind": "historic", "count": len(historics)}, nil)
+				publishFetched(eventBus, tick.SourceGdax, product, 0, 0)
+
+				for _, v := range historics {
+					store.Store(bus.Event{
+						Type: bus.TypeCandle,
+						Source: tick.SourceGdax,
+						Product: product,
+						Timestamp: v.Time,
+						Interval: 60,
+						Candle: &candle.Candle{Time: v.Time, Open: v.Open, High: v.High, Low: v.Low, Close: v.Close},
+						Record: v,
+						Context: ctx,
+					})
+				}
+			}()
+
+			select {
+			case <-stop:
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+}
+
+type runningFetcher struct {
+	spec fetcherSpec
+	stop chan struct{}
+}
+
+// fetcherSet runs the fetchers of the products of the enabled sources, the fetches already started when a fetcher is stopped still complete
+type fetcherSet struct {
+	eventBus *bus.Bus
+	store *storage
+	sampler *logging.Sampler
+
+	mutex sync.Mutex
+	running map[string]*runningFetcher
+}
+
+func newFetcherSet(eventBus *bus.Bus, store *storage, sampler *logging.Sampler) *fetcherSet {
+	return &fetcherSet{eventBus: eventBus, store: store, sampler: sampler, running: make(map[string]*runningFetcher)}
+}
+
+// Apply starts the fetchers of specs, stops the others and restarts the ones whose interval changed
+func (f *fetcherSet) Apply(specs map[string]fetcherSpec) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for channel, running := range f.running {
+		spec, ok := specs[channel]
+		if ok && spec == running.spec {
+			continue
+		}
+
+		close(running.stop)
+		delete(f.running, channel)
+		log.WithFields(log.Fields{"source": running.spec.source, "product": running.spec.product}).Info("stop fetcher")
+	}
+
+	for channel, spec := range specs {
+		if _, ok := f.running[channel]; ok {
+			continue
+		}
+
+		running := &runningFetcher{spec: spec, stop: make(chan struct{})}
+
+		switch spec.source {
+		case tick.SourceBrti:
+			startBrtiFetcher(f.eventBus, f.store, f.sampler, spec.product, spec.interval, running.stop)
+		case tick.SourceBitstamp:
+			startBitstampFetcher(f.eventBus, f.store, spec.product, spec.interval, running.stop)
+		case tick.SourceGdax:
+			startGdaxFetcher(f.eventBus, f.store, spec.product, spec.interval, running.stop)
+		default:
+			continue
+		}
+
+		f.running[channel] = running
+		log.WithFields(log.Fields{"source": spec.source, "product": spec.product, "interval": spec.interval.String()}).Info("start fetcher")
+	}
+}
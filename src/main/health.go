@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bus"
+	"health"
+	"net/http"
+	"util"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// fetchedSLAs are the SLAs of the fetched products, the others would always be stale
+func fetchedSLAs(config FetcherConfig) []health.SLA {
+	specs, _ := fetcherSpecs(config)
+
+	var result []health.SLA
+	for _, v := range config.SLA {
+		if _, ok := specs[bus.Channel(v.Source, v.Product)]; ok {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func startHealthTracker(config FetcherConfig, eventBus *bus.Bus) *health.Tracker {
+	tracker, err := health.NewTracker(fetchedSLAs(config))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// every channel is subscribed since the tracked sources change when the config is reloaded
+	subscription := eventBus.Subscribe("health", alertBuffer, []string{bus.TypeFetched, bus.TypeFetchError}, []string{bus.AllChannels})
+
+	go tracker.Run(subscription)
+
+	return tracker
+}
+
+func registerHealthRoutes(r *gin.Engine, dbPath string, tracker *health.Tracker) {
+	r.GET("/health", func(c *gin.Context) {
+		report := tracker.Report()
+
+		status := http.StatusOK
+		if !report.Healthy {
+			status = http.StatusServiceUnavailable
+		}
+
+		c.JSON(status, report)
+	})
+
+	r.GET("/ready", func(c *gin.Context) {
+		report := tracker.Report()
+
+		if report.Ready {
+			db, err := util.OpenDB(dbPath)
+			if err != nil {
+				log.WithError(err).Error("open db error")
+				report.Ready = false
+			} else {
+				defer db.Close()
+
+				err = db.Ping()
+				if err != nil {
+					log.WithError(err).Error("ping db error")
+					report.Ready = false
+				}
+			}
+		}
+
+		status := http.StatusOK
+		if !report.Ready {
+			status = http.StatusServiceUnavailable
+		}
+
+		c.JSON(status, gin.H{
+			"ready": report.Ready,
+		})
+	})
+}
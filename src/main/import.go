@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"importer"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+	"util"
+)
+
+// parseMapping reads the column mapping like log_time=date,log_price=close
+func parseMapping(s string) (map[string]string, error) {
+	result := make(map[string]string)
+	if s == "" {
+		return result, nil
+	}
+
+	for _, v := range strings.Split(s, ",") {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, errors.New(fmt.Sprintf("invalid mapping: %v", v))
+		}
+		result[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return result, nil
+}
+
+// runImportCommand imports the csv files given as arguments and prints a report for each file, it returns the exit code
+func runImportCommand(dataPath string, args []string) int {
+	var tables []string
+	for k := range importer.Targets {
+		tables = append(tables, k)
+	}
+	sort.Strings(tables)
+
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	configFile := flags.String("config", "", "path of the config file, config.yaml next to the binary by default")
+	table := flags.String("table", "", fmt.Sprintf("table to import into, %v", strings.Join(tables, ", ")))
+	mappingFlag := flags.String("map", "", "columns mapped to the csv headers like log_time=date,log_price=close, the others are read from the headers of the same name")
+	timeFormat := flags.String("time-format", importer.TimeUnix, "unix, unix_ms or a layout like \"2006-01-02 15:04:05\" read in UTC")
+	delimiter := flags.String("delimiter", ",", "csv delimiter")
+	batchSize := flags.Int("batch", 1000, "rows inserted in each transaction")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: import --table table [options] file.csv...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	target, ok := importer.Targets[*table]
+	if !ok || flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	mapping, err := parseMapping(*mappingFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if utf8.RuneCountInString(*delimiter) != 1 {
+		fmt.Fprintln(os.Stderr, "delimiter should be a single character")
+		return 2
+	}
+
+	options := importer.Options{
+		Mapping: mapping,
+		TimeFormat: *timeFormat,
+		Delimiter: []rune(*delimiter)[0],
+		BatchSize: *batchSize,
+	}
+
+	config, err := initConfig(dataPath, *configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	err = config.Validate()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	initDb(config.DB.Path, config.DB.Sources)
+
+	util.AttachDBs(config.DB.Sources)
+
+	err = checkAttachedDBs(config.DB.Path, config.DB.Sources)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	db, err := util.OpenDB(config.DB.Path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	defer db.Close()
+
+	code := 0
+	for _, file := range flags.Args() {
+		f, err := os.Open(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+			continue
+		}
+
+		report, err := importer.Import(db, f, target, options)
+		f.Close()
+
+		body, _ := json.MarshalIndent(struct {
+			File string `json:"file"`
+			importer.Report
+		}{file, report}, "", "  ")
+		fmt.Println(string(body))
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", file, err)
+			code = 1
+		}
+	}
+
+	return code
+}
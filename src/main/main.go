@@ -3,244 +3,247 @@ package main
 import (
 	"fmt"
 	"net/http"
-	"time"
-	"log"
-	"io/ioutil"
-	"encoding/json"
+	log "github.com/sirupsen/logrus"
 	"path/filepath"
 	"os"
 	_ "github.com/mattn/go-sqlite3"
 	"database/sql"
 	"github.com/gin-gonic/gin"
-	"github.com/spf13/viper"
+	"flag"
 	"strconv"
 	"gdax"
 	"util"
 	"bitstamp"
+	"candle"
+	"tick"
+	"bus"
+	"brti"
+	"alert"
+	"metrics"
+	"logging"
+	"tracing"
+	"context"
+	"time"
+	"os/signal"
+	"syscall"
+	"errors"
+	"strings"
 )
 
-type FetcherConfig struct {
-	Port int64
-	FetchBRTI bool
-	FetchBitstamp bool
-	FetchGdax bool
-}
-
-type BRTI struct {
-	Value float64 `json:"value"`
-	Date string `json:"date"`
-}
-
 type BRTIRESP struct {
 	Timestamp int64 `json:"timestamp"`
 	Price float64 `json:"price"`
 }
 
-func initConfig(configPath string) (FetcherConfig, error) {
-	viper.SetDefault("Port", "8080")
-	viper.SetDefault("FetchBRTI", "false")
-	viper.SetDefault("FetchBitstamp", "true")
-	viper.SetDefault("FetchGdax", "true")
+func main()  {
+	dir, err := filepath.Abs(filepath.Dir(os.Args[0]))
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	viper.SetConfigName("config")
-	viper.AddConfigPath(configPath)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "config":
+			os.Exit(runConfigCommand(dir, os.Args[2:]))
+		case "retention":
+			os.Exit(runRetentionCommand(dir, os.Args[2:]))
+		case "export":
+			os.Exit(runExportCommand(dir, os.Args[2:]))
+		case "import":
+			os.Exit(runImportCommand(dir, os.Args[2:]))
+		}
+	}
 
-	var config FetcherConfig
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	configFile := flags.String("config", "", "path of the config file, config.yaml next to the binary by default")
+	flags.Parse(os.Args[1:])
 
-	err := viper.ReadInConfig()
+	log.WithField("path", dir).Info("running path")
+
+	log.WithField("path", dir).Info("running config")
+	config, err := initConfig(dir, *configFile)
 	if err != nil {
-		log.Println(err)
-		switch err.(type) {
-		case viper.ConfigFileNotFoundError:
-			// did nothing
-			break
-		default:
-			log.Println(err)
-			return config, err
-		}
+		log.Fatal(err)
 	}
 
-	config.Port = viper.GetInt64("Port")
-	config.FetchBRTI = viper.GetBool("FetchBRTI")
-	config.FetchBitstamp = viper.GetBool("FetchBitstamp")
-	config.FetchGdax = viper.GetBool("FetchGdax")
+	err = config.Validate()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	return config, nil
-}
+	err = logging.Configure(config.Log.Level, config.Log.Format)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-func main()  {
-	dir, err := filepath.Abs(filepath.Dir(os.Args[0]))
+	shutdownTracing, err := tracing.Init(config.Tracing)
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Printf("running path: %v", dir)
 
-	log.Printf("running config: %v", dir)
-	config, err := initConfig(dir)
+	defer shutdownTracing(context.Background())
+
+	dbPath := config.DB.Path
+	log.WithField("path", dbPath).Info("running db")
+
+	initDb(dbPath, config.DB.Sources)
+
+	util.AttachDBs(config.DB.Sources)
+
+	err = checkAttachedDBs(dbPath, config.DB.Sources)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	dbPath := fmt.Sprintf("%v/brti.db", dir)
-	log.Printf("running db: %v", dbPath)
+	startCompactor(dbPath, config.Retention)
 
-	initDb(dbPath)
+	volatilityWindow, err := candle.ParseInterval(config.VolatilityWindow)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	if config.FetchBRTI {
-		go func() {
-			for {
-				fetch(dbPath)
-				time.Sleep(time.Millisecond * 500)
-			}
-		}()
+	volatilitySampling, err := candle.ParseInterval(config.VolatilitySampling)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	if config.FetchBitstamp {
-		go func() {
-			for {
-				go func() {
-					ticker, err := bitstamp.FetchTicker(bitstamp.ProductBtcUsd)
-					if err != nil {
-						log.Println(err)
-						return
-					}
-
-					db, err := util.OpenDB(dbPath)
-					if err != nil {
-						log.Printf("open db error: %v\n", err)
-						return
-					}
-					defer db.Close()
-
-					err = bitstamp.SaveTicker(db, &ticker)
-					if err != nil {
-						log.Println(err)
-						return
-					}
-				}()
-
-				time.Sleep(time.Second * 10)
-			}
-		}()
+	eventBus := bus.New()
+
+	store := startStorage(dbPath, config.Storage, eventBus)
+
+	startSink(config.Sink, eventBus)
+
+	startMQTTSink(config.MQTT, eventBus)
+
+	alertEngine := startAlertEngine(dbPath, config.Alerts, eventBus)
+
+	divergenceMonitor := startDivergenceMonitor(dbPath, config.Divergence, eventBus)
+
+	healthTracker := startHealthTracker(config, eventBus)
+
+	startPriceMetrics(eventBus)
+
+	sampler := logging.NewSampler(config.Log.BrtiSampling)
+
+	candleIntervals := newIntervalSet(nil)
+
+	configReloader := &reloader{
+		fetchers: newFetcherSet(eventBus, store, sampler),
+		sampler: sampler,
+		candleIntervals: candleIntervals,
+		alertEngine: alertEngine,
+		healthTracker: healthTracker,
+		current: config,
 	}
 
-	if config.FetchGdax {
-		go func() {
-			for {
-				go func() {
-					ticker, err := gdax.FetchTicker(gdax.ProductBtcUsd)
-					if err != nil {
-						log.Println(err)
-						return
-					}
-
-					db, err := util.OpenDB(dbPath)
-					if err != nil {
-						log.Printf("open db error: %v\n", err)
-						return
-					}
-					defer db.Close()
-
-					err = gdax.SaveTicker(db, &ticker)
-					if err != nil {
-						log.Println(err)
-						return
-					}
-				}()
-
-				go func() {
-					tsEnd := time.Now().Unix()
-
-					tsStart := tsEnd - 120
-
-					historics, err := gdax.FetchHistoric(gdax.ProductBtcUsd, tsStart, tsEnd)
-					if err != nil {
-						log.Println(err)
-						return
-					}
-
-					db, err := util.OpenDB(dbPath)
-					if err != nil {
-						log.Printf("open db error: %v\n", err)
-						return
-					}
-					defer db.Close()
-
-					err = gdax.SaveHistoric(db, historics)
-					if err != nil {
-						log.Println(err)
-						return
-					}
-				}()
-
-				time.Sleep(time.Second * 10)
-			}
-		}()
+	err = configReloader.Apply(config)
+	if err != nil {
+		log.Fatal(err)
 	}
 
+	watchConfig(configReloader)
+
+	handleShutdown(configReloader.fetchers, store, shutdownTracing)
+
+	startCandleMaterializer(dbPath, candleIntervals, eventBus)
+
+	volatilityTrackers := startVolatilityTrackers(dbPath, volatilityWindow, volatilitySampling)
+
 	gin.SetMode(gin.ReleaseMode)
-	r := gin.Default()
+	r := gin.New()
+	r.Use(logging.Middleware(), gin.Recovery(), tracing.Middleware())
+	r.Use(metrics.Middleware())
 
-	r.GET("/brti/timestamp/:timestamp", func(c *gin.Context) {
-		ts := c.Param("timestamp")
+	r.GET("/metrics", metrics.Handler())
 
-		db, err := sql.Open("sqlite3", dbPath)
+	r.GET("/brti/timestamp/:timestamp", func(c *gin.Context) {
+		ts, err := strconv.ParseInt(c.Param("timestamp"), 10, 64)
 		if err != nil {
-			log.Printf("open db error: %v\n", err)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "invalid timestamp",
+			})
 			return
 		}
 
-		defer db.Close()
+		mode := c.DefaultQuery("mode", tick.ModeExact)
 
-		rows, err := db.Query("SELECT log_time,log_price FROM `brti_logs` WHERE `log_time`=?", ts)
-		if err != nil {
-			log.Printf("query brit by timestamp error, timestamp=%v, error=%v\n", ts, err)
+		tolerance, err := strconv.ParseInt(c.DefaultQuery("tolerance", "10"), 10, 64)
+		if err != nil || tolerance < 0 || tolerance > tick.MaxTolerance {
 			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "error",
+				"message": fmt.Sprintf("tolerance should be between 0 and %v", tick.MaxTolerance),
 			})
 			return
 		}
 
-		defer rows.Close()
+		interpolate := c.Query("interpolate") == "true"
 
-		if !rows.Next() {
-			c.JSON(http.StatusNotFound, gin.H{
-				"message": "not found",
+		if mode != tick.ModeExact && mode != tick.ModeBefore && mode != tick.ModeAfter && mode != tick.ModeNearest {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "mode should be exact, before, after or nearest",
 			})
 			return
-		} else {
-			var timestamp int64
-			var price float64
+		}
 
-			err = rows.Scan(&timestamp, &price)
+		table, err := tick.FindTable(tick.SourceBrti, tick.ProductBtcUsd)
+		if err != nil {
+			log.WithError(err).Error("find table error")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "internal server error",
+			})
+			return
+		}
 
-			if err != nil {
-				log.Printf("read brit by timestamp error, timestamp=%v, error=%v\n", ts, err)
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"message": "internal server error",
-				})
-				return
-			}
+		db, err := util.OpenDB(dbPath)
+		if err != nil {
+			log.WithError(err).Error("open db error")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "internal server error",
+			})
+			return
+		}
 
-			c.JSON(http.StatusOK, gin.H{
-				"timestamp": timestamp,
-				"price": price,
+		defer db.Close()
+
+		var result tick.Match
+		queryStart := time.Now()
+		if interpolate {
+			result, err = tick.FindInterpolated(db, table, ts, tolerance)
+		} else {
+			result, err = tick.FindAsOf(db, table, ts, mode, tolerance)
+		}
+		metrics.ObserveQuery(metrics.Route(c), queryStart)
+
+		if err == tick.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "not found",
+			})
+			return
+		}
+
+		if err != nil {
+			log.WithField("timestamp", ts).WithError(err).Error("read brti by timestamp error")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "internal server error",
 			})
+			return
 		}
+
+		c.JSON(http.StatusOK, result)
 	})
 
 	r.GET("/brti/latest", func(c *gin.Context) {
-		db, err := sql.Open("sqlite3", dbPath)
+		db, err := util.OpenDB(dbPath)
 		if err != nil {
-			log.Printf("open db error: %v\n", err)
+			log.WithError(err).Error("open db error")
 			return
 		}
 
 		defer db.Close()
 
+		queryStart := time.Now()
 		rows, err := db.Query("SELECT log_time,log_price FROM `brti_logs` ORDER BY `log_time` DESC LIMIT 10")
 		if err != nil {
-			log.Printf("query brit latest error, error=%v\n", err)
+			log.WithError(err).Error("query brit latest error")
 			c.JSON(http.StatusBadRequest, gin.H{
 				"message": "error",
 			})
@@ -257,7 +260,7 @@ func main()  {
 			err = rows.Scan(&timestamp, &price)
 
 			if err != nil {
-				log.Printf("read brit latest error, error=%v\n", err)
+				log.WithError(err).Error("read brit latest error")
 				c.JSON(http.StatusInternalServerError, gin.H{
 					"message": "internal server error",
 				})
@@ -266,13 +269,15 @@ func main()  {
 
 			resp = append(resp, BRTIRESP{timestamp, price})
 		}
+		metrics.ObserveQuery(metrics.Route(c), queryStart)
+
 		c.JSON(http.StatusOK, resp)
 	})
 
 	r.GET("/bitstamp/btcusd/latest", func(c *gin.Context) {
 		db, err := util.OpenDB(dbPath)
 		if err != nil {
-			log.Printf("open db error: %v\n", err)
+			log.WithError(err).Error("open db error")
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"message": "internal server error",
 			})
@@ -281,10 +286,12 @@ func main()  {
 
 		defer db.Close()
 
+		queryStart := time.Now()
 		result, err := bitstamp.FindTickerLatest(db, 10)
+		metrics.ObserveQuery(metrics.Route(c), queryStart)
 
 		if err != nil {
-			log.Printf("read bitstamp btcusd latest error, error=%v\n", err)
+			log.WithError(err).Error("read bitstamp btcusd latest error")
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"message": "internal server error",
 			})
@@ -297,7 +304,7 @@ func main()  {
 	r.GET("/bitstamp/btcusd/lowest/:start/:end", func(c *gin.Context) {
 		tsStart, err := strconv.ParseInt(c.Param("start"), 10, 64)
 		if err != nil {
-			log.Println(err)
+			log.WithError(err).Debug("parse start error")
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"message": "internal server error",
 			})
@@ -305,7 +312,7 @@ func main()  {
 
 		tsEnd, err := strconv.ParseInt(c.Param("end"), 10, 64)
 		if err != nil {
-			log.Println(err)
+			log.WithError(err).Debug("parse end error")
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"message": "internal server error",
 			})
@@ -313,7 +320,7 @@ func main()  {
 
 		db, err := util.OpenDB(dbPath)
 		if err != nil {
-			log.Printf("open db error: %v\n", err)
+			log.WithError(err).Error("open db error")
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"message": "internal server error",
 			})
@@ -322,10 +329,44 @@ func main()  {
 
 		defer db.Close()
 
+		queryStart := time.Now()
 		result, err := bitstamp.FindHistoricLowest(db, tsStart, tsEnd)
+		metrics.ObserveQuery(metrics.Route(c), queryStart)
+
+		if err != nil {
+			log.WithError(err).Error("read bitstamp btcusd lowest error")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "internal server error",
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	})
+
+	r.GET("/bitstamp/btcusd/highest/:start/:end", func(c *gin.Context) {
+		tsStart, tsEnd, ok := parseRange(c)
+		if !ok {
+			return
+		}
+
+		db, err := util.OpenDB(dbPath)
+		if err != nil {
+			log.WithError(err).Error("open db error")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "internal server error",
+			})
+			return
+		}
+
+		defer db.Close()
+
+		queryStart := time.Now()
+		result, err := bitstamp.FindHistoricHighest(db, tsStart, tsEnd)
+		metrics.ObserveQuery(metrics.Route(c), queryStart)
 
 		if err != nil {
-			log.Printf("read bitstamp btcusd lowest error, error=%v\n", err)
+			log.WithError(err).Error("read bitstamp btcusd highest error")
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"message": "internal server error",
 			})
@@ -338,7 +379,7 @@ func main()  {
 	r.GET("/gdax/btcusd/latest", func(c *gin.Context) {
 		db, err := util.OpenDB(dbPath)
 		if err != nil {
-			log.Printf("open db error: %v\n", err)
+			log.WithError(err).Error("open db error")
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"message": "internal server error",
 			})
@@ -347,10 +388,12 @@ func main()  {
 
 		defer db.Close()
 
+		queryStart := time.Now()
 		result, err := gdax.FindTickerLatest(db, 10)
+		metrics.ObserveQuery(metrics.Route(c), queryStart)
 
 		if err != nil {
-			log.Printf("read gdax btcusd latest error, error=%v\n", err)
+			log.WithError(err).Error("read gdax btcusd latest error")
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"message": "internal server error",
 			})
@@ -363,7 +406,7 @@ func main()  {
 	r.GET("/gdax/btcusd/lowest/:start/:end", func(c *gin.Context) {
 		tsStart, err := strconv.ParseInt(c.Param("start"), 10, 64)
 		if err != nil {
-			log.Println(err)
+			log.WithError(err).Debug("parse start error")
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"message": "internal server error",
 			})
@@ -371,7 +414,7 @@ func main()  {
 
 		tsEnd, err := strconv.ParseInt(c.Param("end"), 10, 64)
 		if err != nil {
-			log.Println(err)
+			log.WithError(err).Debug("parse end error")
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"message": "internal server error",
 			})
@@ -379,7 +422,7 @@ func main()  {
 
 		db, err := util.OpenDB(dbPath)
 		if err != nil {
-			log.Printf("open db error: %v\n", err)
+			log.WithError(err).Error("open db error")
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"message": "internal server error",
 			})
@@ -388,10 +431,12 @@ func main()  {
 
 		defer db.Close()
 
+		queryStart := time.Now()
 		result, err := gdax.FindHistoricLowest(db, tsStart, tsEnd)
+		metrics.ObserveQuery(metrics.Route(c), queryStart)
 
 		if err != nil {
-			log.Printf("read gdax btcusd lowest error, error=%v\n", err)
+			log.WithError(err).Error("read gdax btcusd lowest error")
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"message": "internal server error",
 			})
@@ -401,122 +446,165 @@ func main()  {
 		c.JSON(http.StatusOK, result)
 	})
 
-	r.Run(fmt.Sprintf(":%v", config.Port)) // listen and serve on 0.0.0.0:8080
-}
+	r.GET("/gdax/btcusd/highest/:start/:end", func(c *gin.Context) {
+		tsStart, tsEnd, ok := parseRange(c)
+		if !ok {
+			return
+		}
 
-func fetch(dbPath string)  {
-	maxConcurrent := 3
+		db, err := util.OpenDB(dbPath)
+		if err != nil {
+			log.WithError(err).Error("open db error")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "internal server error",
+			})
+			return
+		}
 
-	for i := 0; i < maxConcurrent; i++ {
-		go func() {
-			brti, err:= fetchOnce()
-			if err != nil {
-				log.Println(err)
-				return
-			}
+		defer db.Close()
 
-			log.Printf("fetched brti price=%v, date=%v\n", brti.Value, brti.Date)
+		queryStart := time.Now()
+		result, err := gdax.FindHistoricHighest(db, tsStart, tsEnd)
+		metrics.ObserveQuery(metrics.Route(c), queryStart)
 
-			brtiTime, err := time.Parse("2006-01-02 15:04:05", brti.Date)
-			if err != nil {
-				log.Printf("parse brti date error: %v\n", brti.Date)
-				return
-			}
+		if err != nil {
+			log.WithError(err).Error("read gdax btcusd highest error")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "internal server error",
+			})
+			return
+		}
 
-			ts := brtiTime.Unix()
+		c.JSON(http.StatusOK, result)
+	})
 
-			log.Printf("fetched brti timestamp: %v\n", ts)
+	registerTickRoutes(r, dbPath)
 
-			db, err := sql.Open("sqlite3", dbPath)
-			if err != nil {
-				log.Printf("open db error: %v\n", err)
-				return
-			}
+	registerCandleRoutes(r, dbPath, candleIntervals)
 
-			defer db.Close()
+	registerExportRoutes(r, dbPath, candleIntervals)
 
-			saveSql := "INSERT OR IGNORE INTO `brti_logs`(`log_time`,`log_price`) VALUES(?,?)"
-			stmt, err := db.Prepare(saveSql)
-			if err != nil {
-				log.Printf("prepare stmt error: %v\n", err)
-				return
-			}
+	registerCompareRoutes(r, dbPath)
 
-			defer stmt.Close()
+	registerAnalyticsRoutes(r, dbPath, volatilityTrackers)
 
-			res, err := stmt.Exec(ts, brti.Value)
-			if err != nil {
-				log.Printf("exec save sql error: %v\n", err)
-				return
-			}
+	registerStreamRoutes(r, dbPath, eventBus)
 
-			affectedRows, err := res.RowsAffected()
-			if err != nil {
-				log.Println(err)
-				return
-			}
+	registerWebsocketRoutes(r, dbPath, eventBus)
 
-			if affectedRows > 0 {
-				log.Printf("saved brti log, timestamp=%v, price=%v\n", ts, brti.Value)
-			}
-		}()
-	}
+	registerAlertRoutes(r, dbPath, alertEngine, divergenceMonitor)
+
+	registerHealthRoutes(r, dbPath, healthTracker)
+
+	r.GET("/bus/stats", func(c *gin.Context) {
+		c.JSON(http.StatusOK, eventBus.Stats())
+	})
+
+	r.Run(fmt.Sprintf(":%v", config.Port)) // listen and serve on 0.0.0.0:8080
 }
 
-func fetchOnce() (BRTI, error) {
-	url := fmt.Sprintf("https://www.cmegroup.com/CmeWS/mvc/Bitcoin/BRTI?_=%v", time.Now().Unix())
-	log.Printf("Fetch url %v\n", url)
+// handleShutdown stops the fetchers on SIGTERM or interrupt and writes the rows still queued in the storage before exiting
+func handleShutdown(fetchers *fetcherSet, store *storage, shutdownTracing func(context.Context) error) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	go func() {
+		sig := <-signals
+		log.WithField("signal", sig.String()).Info("shutting down")
 
-	var brti BRTI
+		fetchers.Apply(nil)
+		store.Close()
+		shutdownTracing(context.Background())
+
+		log.Info("shut down")
+		os.Exit(0)
+	}()
+}
 
-	httpClient := http.Client{
-		Timeout: time.Second * 5,
+// checkAttachedDBs fails when the main db has a table of a source having its own db file, the queries by the table name
+// would read and write the main db instead of the source's file. It should be called after AttachDBs
+func checkAttachedDBs(dbPath string, sources map[string]string) error {
+	if len(sources) == 0 {
+		return nil
 	}
 
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	db, err := util.OpenDB(dbPath)
 	if err != nil {
-		log.Println(err)
-		return brti, err
+		return err
 	}
 
-	res, getErr := httpClient.Do(req)
-	if getErr != nil {
-		log.Println(getErr)
-		return brti, getErr
-	}
+	defer db.Close()
 
-	defer res.Body.Close()
+	shadowed, err := util.ShadowedTables(db, sources)
+	if err != nil {
+		return err
+	}
 
-	body, readErr := ioutil.ReadAll(res.Body)
-	if readErr != nil {
-		log.Println(readErr)
-		return brti, readErr
+	if len(shadowed) > 0 {
+		return errors.New(fmt.Sprintf("the main db %v has tables of the sources having their own db files, move or drop them: %v", dbPath, strings.Join(shadowed, ", ")))
 	}
 
-	brti = BRTI{}
+	return nil
+}
 
-	jsonErr := json.Unmarshal(body, &brti)
-	if jsonErr != nil {
-		log.Println(jsonErr)
-		return brti, jsonErr
+// journalMode is WAL so the long reads like the exports do not lock out the writers, unless the sources have their own db files:
+// a transaction over attached db files, like the retention rolling up the ticks of a source into the candles of the main db,
+// is only committed atomically across the files with the rollback journal
+func journalMode(sources map[string]string) string {
+	if len(sources) > 0 {
+		return "DELETE"
 	}
-
-	return brti, nil
+	return "WAL"
 }
 
-func initDb(dbPath string) {
-	db, err := sql.Open("sqlite3", dbPath)
+// initDb creates the tables, the tick tables of the sources having their own db files are created in those files
+func initDb(dbPath string, sources map[string]string) {
+	db, err := util.OpenDB(dbPath)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	defer db.Close()
 
-	util.CheckAndCreateTable(db,
-		"brti_logs",
-		"CREATE TABLE `brti_logs` (`log_time` BIGINT PRIMARY KEY,`log_price` DECIMAL(10,2) NOT NULL,`created_time` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)")
+	mode := journalMode(sources)
+	log.WithField("mode", mode).Info("db journal mode")
+
+	err = util.ExecuteStmtSql(db, fmt.Sprintf("PRAGMA journal_mode=%v", mode))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, v := range []struct {
+		source string
+		init func(db *sql.DB)
+	}{
+		{tick.SourceBrti, brti.InitDb},
+		{tick.SourceBitstamp, bitstamp.InitDb},
+		{tick.SourceGdax, gdax.InitDb},
+	} {
+		file, ok := sources[v.source]
+		if !ok {
+			v.init(db)
+			continue
+		}
+
+		log.WithFields(log.Fields{"source": v.source, "path": file}).Info("running source db")
+
+		sourceDb, err := util.OpenDB(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = util.ExecuteStmtSql(sourceDb, fmt.Sprintf("PRAGMA journal_mode=%v", mode))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		v.init(sourceDb)
+		sourceDb.Close()
+	}
 
-	bitstamp.InitDb(db)
+	candle.InitDb(db)
 
-	gdax.InitDb(db)
+	alert.InitDb(db)
 }
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"bus"
+	"metrics"
+)
+
+// startPriceMetrics keeps the price gauges at the latest tick of each source
+func startPriceMetrics(eventBus *bus.Bus) {
+	subscription := eventBus.Subscribe("metrics", alertBuffer, []string{bus.TypeTick}, []string{bus.AllChannels})
+
+	go func() {
+		latest := make(map[string]int64)
+
+		for e := range subscription.C {
+			if e.Timestamp <= latest[e.Channel()] {
+				continue
+			}
+			latest[e.Channel()] = e.Timestamp
+
+			metrics.SetPrice(e.Source, e.Product, e.Timestamp, e.Price)
+		}
+	}()
+}
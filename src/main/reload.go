@@ -0,0 +1,186 @@
+package main
+
+import (
+	"alert"
+	"bus"
+	"errors"
+	"fmt"
+	"health"
+	"logging"
+	"reflect"
+	"sync"
+	"tick"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// fetcherSpec is the fetcher of a product of a source
+type fetcherSpec struct {
+	source string
+	product string
+	interval time.Duration
+}
+
+// fetcherSpecs are the fetchers of the products of the enabled sources keyed by their channel
+func fetcherSpecs(config FetcherConfig) (map[string]fetcherSpec, error) {
+	enabled := []struct {
+		source string
+		fetch bool
+		interval string
+	}{
+		{tick.SourceBrti, config.FetchBRTI, config.BrtiInterval},
+		{tick.SourceBitstamp, config.FetchBitstamp, config.BitstampInterval},
+		{tick.SourceGdax, config.FetchGdax, config.GdaxInterval},
+	}
+
+	result := make(map[string]fetcherSpec)
+	for _, v := range enabled {
+		if !v.fetch {
+			continue
+		}
+
+		interval, err := time.ParseDuration(v.interval)
+		if err != nil {
+			return nil, err
+		}
+		if interval <= 0 {
+			return nil, errors.New(fmt.Sprintf("%v interval should be positive", v.source))
+		}
+
+		for _, product := range config.Products[v.source] {
+			result[bus.Channel(v.source, product)] = fetcherSpec{source: v.source, product: product, interval: interval}
+		}
+	}
+
+	return result, nil
+}
+
+// reloader applies the reloadable settings of a changed config file, the others only take effect after a restart
+type reloader struct {
+	fetchers *fetcherSet
+	sampler *logging.Sampler
+	candleIntervals *intervalSet
+	alertEngine *alert.Engine
+	healthTracker *health.Tracker
+
+	mutex sync.Mutex
+	current FetcherConfig
+}
+
+// Apply applies the reloadable settings of the config, nothing is changed if the config is invalid
+func (r *reloader) Apply(config FetcherConfig) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	err := config.Validate()
+	if err != nil {
+		return err
+	}
+
+	// check everything which may fail before changing anything, the old config is kept on error
+	err = r.alertEngine.Check(alert.OriginConfig, config.Alerts)
+	if err != nil {
+		return err
+	}
+
+	slas := fetchedSLAs(config)
+	err = health.Check(slas)
+	if err != nil {
+		return err
+	}
+
+	// the alert rules are checked against the rules added by the api, load them first in case one was added meanwhile
+	err = r.alertEngine.Load(alert.OriginConfig, config.Alerts)
+	if err != nil {
+		return err
+	}
+
+	err = r.healthTracker.Update(slas)
+	if err != nil {
+		return err
+	}
+
+	specs, _ := fetcherSpecs(config)
+	r.fetchers.Apply(specs)
+
+	candleIntervals, _ := parseCandleIntervals(config.CandleIntervals)
+	r.candleIntervals.Set(candleIntervals)
+
+	logging.Configure(config.Log.Level, config.Log.Format)
+	r.sampler.SetEvery(config.Log.BrtiSampling)
+
+	for _, name := range restartRequired(r.current, config) {
+		log.WithField("setting", name).Warn("config changed, restart to apply")
+	}
+
+	r.current = config
+
+	return nil
+}
+
+// restartRequired are the settings changed which are not reloaded
+func restartRequired(old FetcherConfig, config FetcherConfig) []string {
+	var result []string
+
+	settings := []struct {
+		name string
+		old interface{}
+		new interface{}
+	}{
+		{"Port", old.Port, config.Port},
+		{"VolatilityWindow", old.VolatilityWindow, config.VolatilityWindow},
+		{"VolatilitySampling", old.VolatilitySampling, config.VolatilitySampling},
+		{"Sink", old.Sink, config.Sink},
+		{"MQTT", old.MQTT, config.MQTT},
+		{"Divergence", old.Divergence, config.Divergence},
+		{"Tracing", old.Tracing, config.Tracing},
+		{"DB", old.DB, config.DB},
+		{"Retention", old.Retention, config.Retention},
+		{"Storage", old.Storage, config.Storage},
+	}
+
+	for _, v := range settings {
+		if !reflect.DeepEqual(v.old, v.new) {
+			result = append(result, v.name)
+		}
+	}
+
+	return result
+}
+
+// watchConfig reloads the config file when it changes, a config which cannot be read or is invalid is rejected
+func watchConfig(r *reloader) {
+	if viper.ConfigFileUsed() == "" {
+		return
+	}
+
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		entry := log.WithField("file", e.Name)
+
+		// viper keeps the values loaded before when the changed file cannot be parsed, read it again to tell
+		changed := viper.New()
+		changed.SetConfigFile(e.Name)
+		err := changed.ReadInConfig()
+		if err != nil {
+			entry.WithError(err).Error("reload config error, keep the current config")
+			return
+		}
+
+		config, err := readConfig()
+		if err == nil {
+			err = r.Apply(config)
+		}
+
+		if err != nil {
+			entry.WithError(err).Error("reload config error, keep the current config")
+			return
+		}
+
+		entry.Info("config reloaded")
+	})
+
+	viper.WatchConfig()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"alert"
+	"bus"
+	"health"
+	"logging"
+	"testing"
+	"tick"
+)
+
+func TestApplyRejectedConfigChangesNothing(t *testing.T) {
+	eventBus := bus.New()
+	sampler := logging.NewSampler(1)
+
+	alertEngine := alert.NewEngine(nil, eventBus)
+	err := alertEngine.Load(alert.OriginApi, []alert.Rule{{Name: "api rule", Source: tick.SourceGdax, Product: tick.ProductBtcUsd, Kind: alert.KindBelow, Level: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	healthTracker, err := health.NewTracker(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &reloader{
+		fetchers: newFetcherSet(eventBus, nil, sampler),
+		sampler: sampler,
+		candleIntervals: newIntervalSet(nil),
+		alertEngine: alertEngine,
+		healthTracker: healthTracker,
+	}
+
+	config := defaultConfig(t)
+	config.FetchBitstamp = false
+	config.FetchGdax = false
+	config.FetchBRTI = true
+	config.BrtiInterval = "1h"
+	config.Alerts = []alert.Rule{{Name: "config rule", Source: tick.SourceBrti, Product: tick.ProductBtcUsd, Kind: alert.KindAbove, Level: 100}}
+
+	err = r.Apply(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.fetchers.Apply(nil)
+
+	// the config is valid by itself but one of its rules is already added by the api
+	changed := config
+	changed.CandleIntervals = []string{"5m"}
+	changed.Alerts = []alert.Rule{{Name: "api rule", Source: tick.SourceBrti, Product: tick.ProductBtcUsd, Kind: alert.KindAbove, Level: 100}}
+	changed.FetchBRTI = false
+
+	if err = r.Apply(changed); err == nil {
+		t.Fatal("Apply() should reject a rule defined by the api")
+	}
+
+	if intervals := r.candleIntervals.Get(); len(intervals) != 2 {
+		t.Errorf("candle intervals %v, want the ones of the old config", intervals)
+	}
+	if len(r.fetchers.running) != 1 {
+		t.Errorf("%v fetchers running, want the one of the old config", len(r.fetchers.running))
+	}
+	if len(healthTracker.Report().Sources) != 1 {
+		t.Errorf("%v sources tracked, want the one of the old config", len(healthTracker.Report().Sources))
+	}
+	if !r.current.FetchBRTI {
+		t.Error("the current config should be kept")
+	}
+
+	names := make(map[string]bool)
+	for _, v := range alertEngine.Rules() {
+		names[v.Name] = true
+	}
+	if len(names) != 2 || !names["config rule"] || !names["api rule"] {
+		t.Errorf("rules %v, want the config rule and the api rule", names)
+	}
+}
+
+func TestApplyReconcilesProducts(t *testing.T) {
+	eventBus := bus.New()
+	sampler := logging.NewSampler(1)
+
+	healthTracker, err := health.NewTracker(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &reloader{
+		fetchers: newFetcherSet(eventBus, nil, sampler),
+		sampler: sampler,
+		candleIntervals: newIntervalSet(nil),
+		alertEngine: alert.NewEngine(nil, eventBus),
+		healthTracker: healthTracker,
+	}
+	defer r.fetchers.Apply(nil)
+
+	config := defaultConfig(t)
+	config.FetchBitstamp = false
+	config.FetchGdax = false
+	config.FetchBRTI = true
+	config.BrtiInterval = "1h"
+
+	cases := []struct {
+		name string
+		products map[string][]string
+		running int
+		tracked int
+	}{
+		{"product added", map[string][]string{tick.SourceBrti: {tick.ProductBtcUsd}}, 1, 1},
+		{"product removed", map[string][]string{tick.SourceBrti: {}}, 0, 0},
+		{"products of a disabled source", map[string][]string{tick.SourceGdax: {tick.ProductBtcUsd}}, 0, 0},
+	}
+
+	for _, v := range cases {
+		config.Products = v.products
+
+		err = r.Apply(config)
+		if err != nil {
+			t.Fatalf("%v: Apply() = %v", v.name, err)
+		}
+
+		if len(r.fetchers.running) != v.running {
+			t.Errorf("%v: %v fetchers running, want %v", v.name, len(r.fetchers.running), v.running)
+		}
+		if n := len(healthTracker.Report().Sources); n != v.tracked {
+			t.Errorf("%v: %v sources tracked, want %v", v.name, n, v.tracked)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"retention"
+	"time"
+	"util"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// compact applies all the policies once, a failed policy does not stop the others
+func compact(dbPath string, config retention.Config, dryRun bool) []retention.Report {
+	db, err := util.OpenDB(dbPath)
+	if err != nil {
+		log.WithError(err).Error("open db error")
+		return nil
+	}
+
+	defer db.Close()
+
+	result := make([]retention.Report, 0)
+	for _, policy := range config.Policies {
+		start := time.Now()
+
+		report, err := retention.Compact(db, policy, time.Now().Unix(), config.BatchSize, dryRun)
+		if err != nil {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"source": report.Source,
+			"product": report.Product,
+			"cutoff": report.Cutoff,
+			"rows": report.Rows,
+			"candles": report.Candles,
+			"dryRun": report.DryRun,
+			"duration": time.Since(start).Seconds(),
+		}).Info("compacted ticks")
+
+		result = append(result, report)
+	}
+
+	return result
+}
+
+// startCompactor applies the retention policies every interval in the background
+func startCompactor(dbPath string, config retention.Config) {
+	if !config.Enabled {
+		return
+	}
+
+	interval, _ := time.ParseDuration(config.Interval)
+
+	log.WithFields(log.Fields{"interval": interval, "dryRun": config.DryRun}).Info("start compactor")
+
+	go func() {
+		for {
+			compact(dbPath, config, config.DryRun)
+
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// runRetentionCommand applies the retention policies once and prints the reports, it returns the exit code
+func runRetentionCommand(dataPath string, args []string) int {
+	flags := flag.NewFlagSet("retention", flag.ExitOnError)
+	configFile := flags.String("config", "", "path of the config file, config.yaml next to the binary by default")
+	dryRun := flags.Bool("dry-run", false, "report what would be removed without removing it")
+	flags.Parse(args)
+
+	config, err := initConfig(dataPath, *configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	err = config.Validate()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	initDb(config.DB.Path, config.DB.Sources)
+
+	util.AttachDBs(config.DB.Sources)
+
+	err = checkAttachedDBs(config.DB.Path, config.DB.Sources)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	reports := compact(config.DB.Path, config.Retention, *dryRun || config.Retention.DryRun)
+
+	body, err := json.MarshalIndent(reports, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Println(string(body))
+
+	if len(reports) < len(config.Retention.Policies) {
+		return 1
+	}
+
+	return 0
+}
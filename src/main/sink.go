@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bus"
+	"sink"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// sinkBuffer is the number of ticks waiting to be forwarded before new ones are dropped
+const sinkBuffer = 4096
+
+func startSink(config sink.Config, eventBus *bus.Bus) {
+	if config.Type == "" {
+		return
+	}
+
+	publisher, err := sink.NewPublisher(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	spool, err := sink.NewSpool(config.SpoolPath, config.SpoolMaxBytes)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	subscription := eventBus.Subscribe("sink", sinkBuffer, []string{bus.TypeTick}, []string{bus.AllChannels})
+
+	log.WithField("type", config.Type).Info("forward ticks to sink")
+
+	go sink.New(config, publisher, spool).Run(subscription)
+}
+
+func startMQTTSink(config sink.MQTTConfig, eventBus *bus.Bus) {
+	if config.Broker == "" {
+		return
+	}
+
+	// the broker may be unavailable, the fetcher keeps running and the sink connects once it is reachable
+	mqttSink, err := sink.NewMQTTSink(config)
+	if err != nil {
+		log.WithField("broker", config.Broker).WithError(err).Error("start mqtt sink error")
+		return
+	}
+
+	subscription := eventBus.Subscribe("mqtt", sinkBuffer, []string{bus.TypeTick}, []string{bus.AllChannels})
+
+	log.WithField("broker", config.Broker).Info("publish latest prices to mqtt")
+
+	go mqttSink.Run(subscription)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"batch"
+	"bitstamp"
+	"brti"
+	"bus"
+	"context"
+	"database/sql"
+	"gdax"
+	"metrics"
+	"sync"
+	"tracing"
+	"util"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// observeRows counts the rows of a written batch by table and result
+func observeRows(results []batch.Result) {
+	for _, v := range results {
+		switch {
+		case v.Saved && v.Row.Replace:
+			metrics.ObserveRow(v.Row.Table, metrics.RowsReplaced)
+		case v.Saved:
+			metrics.ObserveRow(v.Row.Table, metrics.RowsInserted)
+		default:
+			metrics.ObserveRow(v.Row.Table, metrics.RowsIgnored)
+		}
+	}
+}
+
+// storage saves the ticks and candles of the fetchers in batches, an event is published on the bus only once its row is saved
+// so the subscribers never see a tick missing from the db or the same tick fetched twice
+type storage struct {
+	db *sql.DB
+	writer *batch.Writer
+	eventBus *bus.Bus
+
+	closeOnce sync.Once
+}
+
+func startStorage(dbPath string, config batch.Config, eventBus *bus.Bus) *storage {
+	db, err := util.OpenDB(dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s := &storage{db: db, eventBus: eventBus}
+	s.writer = batch.New(db, config, s.written)
+
+	return s
+}
+
+// Store queues the row of the event, it blocks while the writer queue is full so the fetchers slow down when the db can not keep up
+func (s *storage) Store(e bus.Event) {
+	var row util.Row
+	var err error
+
+	switch record := e.Record.(type) {
+	case brti.Ticker:
+		row = brti.TickerRow(&record)
+	case bitstamp.Ticker:
+		row = bitstamp.TickerRow(&record)
+	case gdax.Ticker:
+		row, err = gdax.TickerRow(&record)
+	case gdax.Historic:
+		row, err = gdax.HistoricRow(&record)
+	default:
+		log.WithFields(log.Fields{"source": e.Source, "product": e.Product, "type": e.Type}).Error("store event error, no record")
+		return
+	}
+
+	if err != nil {
+		log.WithFields(log.Fields{"source": e.Source, "product": e.Product}).WithError(err).Error("store event error")
+		return
+	}
+
+	ctx := e.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// the span covers the wait for the writer, the rows are written later in a batch by a span linked to this one
+	ctx, span := tracing.Start(ctx, "store", attribute.String("source", e.Source), attribute.String("product", e.Product), attribute.String("table", row.Table))
+	ok := s.writer.Add(ctx, row, e)
+	tracing.End(span, nil)
+
+	if !ok {
+		log.WithFields(log.Fields{"source": e.Source, "product": e.Product}).Warn("store event after storage closed")
+		metrics.ObserveRow(row.Table, metrics.RowsFailed)
+	}
+}
+
+// written publishes the events whose rows are saved, the ones already stored are dropped as duplicates
+func (s *storage) written(results []batch.Result) {
+	observeRows(results)
+
+	for _, v := range results {
+		if v.Saved {
+			s.eventBus.Publish(v.Data.(bus.Event))
+		}
+	}
+}
+
+// Close writes the queued rows, the events stored after, or blocked on the full queue meanwhile, are dropped
+func (s *storage) Close() {
+	s.closeOnce.Do(func() {
+		s.writer.Close()
+		s.db.Close()
+	})
+}
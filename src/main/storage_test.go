@@ -0,0 +1,53 @@
+package main
+
+import (
+	"batch"
+	"brti"
+	"bus"
+	"path/filepath"
+	"testing"
+	"tick"
+	"util"
+)
+
+func TestStoragePublishesSavedEvents(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := util.OpenDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	brti.InitDb(db)
+
+	eventBus := bus.New()
+	subscription := eventBus.Subscribe("test", 16, []string{bus.TypeTick}, []string{bus.AllChannels})
+
+	store := startStorage(dbPath, batch.Config{Size: 10, FlushInterval: "10ms", QueueSize: 10, Retries: 1, RetryDelay: "10ms", MaxRetryDelay: "10ms"}, eventBus)
+
+	// the same tick is fetched twice, it is only published once
+	for _, v := range []brti.Ticker{{Timestamp: 100, Price: 1}, {Timestamp: 100, Price: 1}, {Timestamp: 101, Price: 2}} {
+		store.Store(bus.Event{Type: bus.TypeTick, Source: tick.SourceBrti, Product: tick.ProductBtcUsd, Timestamp: v.Timestamp, Price: v.Price, Record: v})
+	}
+	store.Close()
+
+	// stored after close, dropped rather than panicking
+	store.Store(bus.Event{Type: bus.TypeTick, Source: tick.SourceBrti, Product: tick.ProductBtcUsd, Timestamp: 102, Price: 3, Record: brti.Ticker{Timestamp: 102, Price: 3}})
+
+	var published []int64
+	for len(subscription.C) > 0 {
+		published = append(published, (<-subscription.C).Timestamp)
+	}
+	if len(published) != 2 || published[0] != 100 || published[1] != 101 {
+		t.Errorf("published %v, want [100 101]", published)
+	}
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM `brti_logs`").Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("%v rows saved, want 2", count)
+	}
+}
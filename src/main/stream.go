@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bus"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"tick"
+	"time"
+	"util"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// streamBuffer is the number of events buffered for each client
+const streamBuffer = 256
+
+// parseEventId reads the per source positions from the last event id, like brti:1525441234,gdax:1525441230
+func parseEventId(id string) map[string]int64 {
+	positions := make(map[string]int64)
+
+	for _, v := range strings.Split(id, ",") {
+		parts := strings.SplitN(v, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		ts, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		positions[parts[0]] = ts
+	}
+
+	return positions
+}
+
+func formatEventId(tables []tick.Table, positions map[string]int64) string {
+	var parts []string
+	for _, table := range tables {
+		if ts, ok := positions[table.Source]; ok {
+			parts = append(parts, fmt.Sprintf("%v:%v", table.Source, ts))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func registerStreamRoutes(r *gin.Engine, dbPath string, eventBus *bus.Bus) {
+	r.GET("/stream", func(c *gin.Context) {
+		tables, ok := parseSources(c, "brti,gdax,bitstamp")
+		if !ok {
+			return
+		}
+
+		var channels []string
+		for _, table := range tables {
+			channels = append(channels, bus.Channel(table.Source, table.Product))
+		}
+
+		// subscribe before replaying so no tick is missed in between
+		since := time.Now().Unix()
+		subscription := eventBus.Subscribe("sse", streamBuffer, []string{bus.TypeTick}, channels)
+		defer eventBus.Unsubscribe(subscription)
+
+		lastEventId := c.GetHeader("Last-Event-ID")
+		if lastEventId == "" {
+			lastEventId = c.Query("last_event_id")
+		}
+
+		positions := parseEventId(lastEventId)
+
+		db, err := util.OpenDB(dbPath)
+		if err != nil {
+			log.WithError(err).Error("open db error")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "internal server error",
+			})
+			return
+		}
+
+		defer db.Close()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+
+		// the ticks fetched concurrently may be saved out of order, only send the ones newer than the last sent
+		send := func(e bus.Event) {
+			if ts, ok := positions[e.Source]; ok && e.Timestamp <= ts {
+				return
+			}
+			positions[e.Source] = e.Timestamp
+
+			c.Render(-1, sse.Event{
+				Id: formatEventId(tables, positions),
+				Event: "tick",
+				Data: e,
+			})
+		}
+
+		// replay sends the ticks saved after the start of each source page by page until it is caught up,
+		// the live ticks meanwhile wait in the subscription
+		replay := func(start map[string]int64) {
+			for _, table := range tables {
+				ts, ok := start[table.Source]
+				if !ok {
+					continue
+				}
+
+				cursor := ""
+				for c.Request.Context().Err() == nil {
+					page, err := tick.FindPage(db, table, ts+1, time.Now().Unix(), cursor, tick.MaxPageSize, false)
+					if err != nil {
+						log.WithFields(log.Fields{"source": table.Source, "product": table.Product}).WithError(err).Error("replay ticks error")
+						break
+					}
+
+					for _, v := range page.Ticks {
+						send(bus.Event{Type: bus.TypeTick, Source: table.Source, Product: table.Product, Timestamp: v.Timestamp, Price: v.Price})
+					}
+					c.Writer.Flush()
+
+					if page.NextCursor == "" {
+						break
+					}
+					cursor = page.NextCursor
+				}
+			}
+		}
+
+		// catchUp replays from the last tick sent, or from the subscription for the sources not sent yet,
+		// as long as the subscription dropped ticks since it was caught up last, the ticks are published once saved so the db has them
+		dropped := int64(0)
+		catchUp := func() {
+			for subscription.Dropped() != dropped && c.Request.Context().Err() == nil {
+				dropped = subscription.Dropped()
+				log.WithField("dropped", dropped).Warn("stream client too slow, replay the dropped ticks")
+
+				start := make(map[string]int64)
+				for _, table := range tables {
+					start[table.Source] = since - 1
+					if ts, ok := positions[table.Source]; ok {
+						start[table.Source] = ts
+					}
+				}
+				replay(start)
+			}
+		}
+
+		if len(positions) > 0 {
+			start := make(map[string]int64)
+			for k, v := range positions {
+				start[k] = v
+			}
+			replay(start)
+		}
+		catchUp()
+		c.Writer.Flush()
+
+		heartbeat := time.NewTicker(time.Second * 15)
+		defer heartbeat.Stop()
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case e := <-subscription.C:
+				catchUp()
+				send(e)
+			case <-heartbeat.C:
+				io.WriteString(w, ": ping\n\n")
+			case <-c.Writer.CloseNotify():
+				return false
+			}
+			return true
+		})
+	})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"brti"
+	"bus"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"tick"
+	"time"
+	"util"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStreamSendsEveryTickInOrder(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := util.OpenDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	brti.InitDb(db)
+
+	save := func(from int64, to int64) {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for ts := from; ts <= to; ts++ {
+			_, err = tx.Exec("INSERT INTO `brti_logs`(`log_time`,`log_price`) VALUES(?,?)", ts, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = tx.Commit()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// more ticks than a page to replay, then more live ticks than the subscription buffers
+	save(1, 2000)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	eventBus := bus.New()
+	registerStreamRoutes(r, dbPath, eventBus)
+
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/stream?sources=brti&last_event_id=brti:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	received := make(chan int64, 4096)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "data:") {
+				continue
+			}
+
+			var e bus.Event
+			json.Unmarshal([]byte(strings.TrimPrefix(line, "data:")), &e)
+			received <- e.Timestamp
+		}
+		close(received)
+	}()
+
+	next := int64(1)
+	expect := func(last int64) {
+		deadline := time.After(time.Second * 10)
+		for next <= last {
+			select {
+			case ts, ok := <-received:
+				if !ok {
+					t.Fatalf("stream closed at %v", next)
+				}
+				if ts != next {
+					t.Fatalf("received tick %v, want %v", ts, next)
+				}
+				next++
+			case <-deadline:
+				t.Fatalf("timeout at tick %v", next)
+			}
+		}
+	}
+
+	expect(2000)
+
+	save(2001, 3000)
+	for ts := int64(2001); ts <= 3000; ts++ {
+		eventBus.Publish(bus.Event{Type: bus.TypeTick, Source: tick.SourceBrti, Product: tick.ProductBtcUsd, Timestamp: ts, Price: 1})
+	}
+
+	expect(3000)
+}
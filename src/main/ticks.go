@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"metrics"
+	"net/http"
+	"strconv"
+	"strings"
+	"tick"
+	"time"
+	"util"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// parseRange reads the :start and :end params, responds bad request when they are invalid
+func parseRange(c *gin.Context) (int64, int64, bool) {
+	tsStart, err := strconv.ParseInt(c.Param("start"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "invalid start",
+		})
+		return 0, 0, false
+	}
+
+	tsEnd, err := strconv.ParseInt(c.Param("end"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "invalid end",
+		})
+		return 0, 0, false
+	}
+
+	if tsEnd < tsStart {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "invalid time range",
+		})
+		return 0, 0, false
+	}
+
+	return tsStart, tsEnd, true
+}
+
+// defaultPageSize is the number of ticks returned when limit is not given
+const defaultPageSize = 100
+
+// parseQueryRange reads the optional start and end query, the whole history until now by default
+func parseQueryRange(c *gin.Context) (int64, int64, bool) {
+	var err error
+
+	tsEnd := time.Now().Unix()
+	if c.Query("end") != "" {
+		tsEnd, err = strconv.ParseInt(c.Query("end"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "invalid end",
+			})
+			return 0, 0, false
+		}
+	}
+
+	var tsStart int64
+	if c.Query("start") != "" {
+		tsStart, err = strconv.ParseInt(c.Query("start"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "invalid start",
+			})
+			return 0, 0, false
+		}
+	}
+
+	if tsEnd < tsStart {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "invalid time range",
+		})
+		return 0, 0, false
+	}
+
+	return tsStart, tsEnd, true
+}
+
+// parseSources reads the comma separated sources query of the product query, responds bad request on unknown sources
+func parseSources(c *gin.Context, defaultSources string) ([]tick.Table, bool) {
+	product := c.DefaultQuery("product", tick.ProductBtcUsd)
+
+	var tables []tick.Table
+	for _, source := range strings.Split(c.DefaultQuery("sources", defaultSources), ",") {
+		table, err := tick.FindTable(strings.TrimSpace(source), product)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": err.Error(),
+			})
+			return nil, false
+		}
+		tables = append(tables, table)
+	}
+
+	return tables, true
+}
+
+func registerTickRoutes(r *gin.Engine, dbPath string) {
+	for _, v := range tick.Tables {
+		table := v
+
+		r.GET(fmt.Sprintf("/%v/%v/ticks", table.Source, table.Product), func(c *gin.Context) {
+			tsStart, tsEnd, ok := parseQueryRange(c)
+			if !ok {
+				return
+			}
+
+			limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageSize)))
+			if err != nil || limit < 1 || limit > tick.MaxPageSize {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": fmt.Sprintf("limit should be between 1 and %v", tick.MaxPageSize),
+				})
+				return
+			}
+
+			var desc bool
+			switch c.DefaultQuery("order", "asc") {
+			case "asc":
+				desc = false
+			case "desc":
+				desc = true
+			default:
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": "order should be asc or desc",
+				})
+				return
+			}
+
+			db, err := util.OpenDB(dbPath)
+			if err != nil {
+				log.WithError(err).Error("open db error")
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"message": "internal server error",
+				})
+				return
+			}
+
+			defer db.Close()
+
+			queryStart := time.Now()
+			result, err := tick.FindPage(db, table, tsStart, tsEnd, c.Query("cursor"), limit, desc)
+			metrics.ObserveQuery(metrics.Route(c), queryStart)
+			if err == tick.ErrInvalidCursor {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": err.Error(),
+				})
+				return
+			}
+
+			if err != nil {
+				log.WithFields(log.Fields{"source": table.Source, "product": table.Product}).WithError(err).Error("read ticks error")
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"message": "internal server error",
+				})
+				return
+			}
+
+			c.JSON(http.StatusOK, result)
+		})
+
+		r.GET(fmt.Sprintf("/%v/%v/stats/:start/:end", table.Source, table.Product), func(c *gin.Context) {
+			tsStart, tsEnd, ok := parseRange(c)
+			if !ok {
+				return
+			}
+
+			db, err := util.OpenDB(dbPath)
+			if err != nil {
+				log.WithError(err).Error("open db error")
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"message": "internal server error",
+				})
+				return
+			}
+
+			defer db.Close()
+
+			queryStart := time.Now()
+			result, err := tick.FindStats(db, table, tsStart, tsEnd)
+			metrics.ObserveQuery(metrics.Route(c), queryStart)
+			if err != nil {
+				log.WithFields(log.Fields{"source": table.Source, "product": table.Product}).WithError(err).Error("read stats error")
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"message": "internal server error",
+				})
+				return
+			}
+
+			c.JSON(http.StatusOK, result)
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bus"
+	"net/http"
+	"strings"
+	"sync"
+	"tick"
+	"time"
+	"util"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// wsBuffer is the number of events buffered for each connection
+	wsBuffer = 256
+	// wsMaxDropped is the number of dropped events after which a connection is considered too slow and closed
+	wsMaxDropped = 1000
+	wsWriteWait = time.Second * 10
+	wsPongWait = time.Second * 60
+	wsPingPeriod = time.Second * 50
+	defaultSnapshotSize = 10
+	maxSnapshotSize = 100
+)
+
+// wsRequest is sent by clients, like {"action":"subscribe","channels":["brti/btcusd"],"snapshot":10}
+type wsRequest struct {
+	Action string `json:"action"`
+	Channels []string `json:"channels"`
+	Snapshot *int `json:"snapshot"`
+}
+
+// wsMessage is the control message sent to clients, events are sent as they are
+type wsMessage struct {
+	Type string `json:"type"`
+	Channel string `json:"channel,omitempty"`
+	Channels []string `json:"channels,omitempty"`
+	Ticks []tick.Tick `json:"ticks,omitempty"`
+	Count int64 `json:"count,omitempty"`
+	Message string `json:"message,omitempty"`
+	// release is the channels whose live events are sent once the message is written
+	release []string
+}
+
+// wsPending is the channels subscribed but waiting for their snapshot, their live events are held back until it is sent
+type wsPending struct {
+	mutex sync.Mutex
+	channels map[string]bool
+}
+
+func (p *wsPending) Hold(channel string) {
+	p.mutex.Lock()
+	p.channels[channel] = true
+	p.mutex.Unlock()
+}
+
+func (p *wsPending) Release(channel string) {
+	p.mutex.Lock()
+	delete(p.channels, channel)
+	p.mutex.Unlock()
+}
+
+func (p *wsPending) Held(channel string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.channels[channel]
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize: 1024,
+	WriteBufferSize: 1024,
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+func findChannelTable(channel string) (tick.Table, error) {
+	parts := strings.SplitN(channel, "/", 2)
+	if len(parts) != 2 {
+		return tick.FindTable(channel, "")
+	}
+	return tick.FindTable(parts[0], parts[1])
+}
+
+func registerWebsocketRoutes(r *gin.Engine, dbPath string, eventBus *bus.Bus) {
+	r.GET("/ws", func(c *gin.Context) {
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			log.WithError(err).Error("upgrade websocket error")
+			return
+		}
+
+		defer conn.Close()
+
+		subscription := eventBus.Subscribe("websocket", wsBuffer, []string{bus.TypeTick, bus.TypeCandle, bus.TypeAlert}, nil)
+		defer eventBus.Unsubscribe(subscription)
+
+		out := make(chan wsMessage, 16)
+		done := make(chan struct{})
+		pending := &wsPending{channels: make(map[string]bool)}
+
+		go wsWrite(conn, subscription, pending, out, done)
+
+		conn.SetReadLimit(4096)
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		conn.SetPongHandler(func(string) error {
+			conn.SetReadDeadline(time.Now().Add(wsPongWait))
+			return nil
+		})
+
+		defer close(done)
+
+		for {
+			var req wsRequest
+
+			err := conn.ReadJSON(&req)
+			if err != nil {
+				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+					log.WithError(err).Error("read websocket error")
+				}
+				return
+			}
+
+			var messages []wsMessage
+			switch req.Action {
+			case "subscribe":
+				messages = wsSubscribe(dbPath, subscription, pending, req)
+			case "unsubscribe":
+				for _, channel := range req.Channels {
+					subscription.Remove(channel)
+				}
+				messages = []wsMessage{{Type: "unsubscribed", Channels: req.Channels}}
+			default:
+				messages = []wsMessage{{Type: "error", Message: "action should be subscribe or unsubscribe"}}
+			}
+
+			for _, m := range messages {
+				select {
+				case out <- m:
+				case <-done:
+					return
+				}
+			}
+		}
+	})
+}
+
+// wsSubscribe adds the channels and reads the snapshot of the latest ticks of each one.
+// The channels are held pending from before they are added until the last message is written, so no live event is missed or sent ahead of the snapshot
+func wsSubscribe(dbPath string, subscription *bus.Subscription, pending *wsPending, req wsRequest) (messages []wsMessage) {
+	size := defaultSnapshotSize
+	if req.Snapshot != nil {
+		size = *req.Snapshot
+	}
+
+	if size < 0 || size > maxSnapshotSize {
+		return []wsMessage{{Type: "error", Message: "snapshot out of range"}}
+	}
+
+	var tables []tick.Table
+	for _, channel := range req.Channels {
+		table, err := findChannelTable(channel)
+		if err != nil {
+			return []wsMessage{{Type: "error", Message: err.Error()}}
+		}
+		tables = append(tables, table)
+	}
+
+	var channels []string
+	for _, table := range tables {
+		channel := bus.Channel(table.Source, table.Product)
+		pending.Hold(channel)
+		subscription.Add(channel)
+		channels = append(channels, channel)
+	}
+
+	messages = []wsMessage{{Type: "subscribed", Channels: req.Channels}}
+
+	// the live events of the channels are sent after the last message
+	defer func() {
+		messages[len(messages)-1].release = channels
+	}()
+
+	if size == 0 || len(tables) == 0 {
+		return messages
+	}
+
+	db, err := util.OpenDB(dbPath)
+	if err != nil {
+		log.WithError(err).Error("open db error")
+		return append(messages, wsMessage{Type: "error", Message: "snapshot not available"})
+	}
+
+	defer db.Close()
+
+	for _, table := range tables {
+		page, err := tick.FindPage(db, table, 0, time.Now().Unix(), "", size, true)
+		if err != nil {
+			log.WithFields(log.Fields{"source": table.Source, "product": table.Product}).WithError(err).Error("read snapshot error")
+			messages = append(messages, wsMessage{Type: "error", Message: "snapshot not available"})
+			continue
+		}
+
+		// the page is the latest ticks in descending order, send them in ascending order like the live ones
+		ticks := make([]tick.Tick, len(page.Ticks))
+		for i, v := range page.Ticks {
+			ticks[len(page.Ticks)-1-i] = v
+		}
+
+		messages = append(messages, wsMessage{Type: "snapshot", Channel: bus.Channel(table.Source, table.Product), Ticks: ticks})
+	}
+
+	return messages
+}
+
+// wsWrite is the only writer of the connection, it reports dropped events and closes the connection when the client is too slow
+func wsWrite(conn *websocket.Conn, subscription *bus.Subscription, pending *wsPending, out chan wsMessage, done chan struct{}) {
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+
+	var reported int64
+
+	// the ticks fetched concurrently may be saved out of order, only send the ones newer than the last sent
+	latest := make(map[string]int64)
+
+	// held is the live events of the pending channels
+	held := make(map[string][]bus.Event)
+
+	send := func(e bus.Event) error {
+		if e.Type == bus.TypeTick {
+			if e.Timestamp <= latest[e.Channel()] {
+				return nil
+			}
+			latest[e.Channel()] = e.Timestamp
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		return conn.WriteJSON(e)
+	}
+
+	for {
+		var err error
+
+		select {
+		case e := <-subscription.C:
+			if pending.Held(e.Channel()) {
+				held[e.Channel()] = append(held[e.Channel()], e)
+				continue
+			}
+
+			err = send(e)
+		case m := <-out:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err = conn.WriteJSON(m)
+
+			if m.Type == "snapshot" && len(m.Ticks) > 0 {
+				latest[m.Channel] = m.Ticks[len(m.Ticks)-1].Timestamp
+			}
+
+			for _, channel := range m.release {
+				pending.Release(channel)
+
+				for _, e := range held[channel] {
+					if err == nil {
+						err = send(e)
+					}
+				}
+				delete(held, channel)
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err = conn.WriteMessage(websocket.PingMessage, nil)
+		case <-done:
+			return
+		}
+
+		if err != nil {
+			log.WithError(err).Error("write websocket error")
+			conn.Close()
+			return
+		}
+
+		dropped := subscription.Dropped()
+		if dropped > wsMaxDropped {
+			log.WithField("dropped", dropped).Warn("close slow websocket")
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too slow"), time.Now().Add(wsWriteWait))
+			conn.Close()
+			return
+		}
+
+		if dropped > reported {
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err = conn.WriteJSON(wsMessage{Type: "dropped", Count: dropped - reported})
+			if err != nil {
+				log.WithError(err).Error("write websocket error")
+				conn.Close()
+				return
+			}
+			reported = dropped
+		}
+	}
+}
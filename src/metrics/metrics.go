@@ -0,0 +1,195 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "fetcher"
+
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+const (
+	// RowsInserted is counted when the row is newly saved
+	RowsInserted = "inserted"
+	// RowsIgnored is counted when the row is already stored
+	RowsIgnored = "ignored"
+	// RowsReplaced is counted when the stored row is overwritten, like the gdax candle still in progress
+	RowsReplaced = "replaced"
+	// RowsDeleted is counted when the row is removed by the retention
+	RowsDeleted = "deleted"
+	// RowsFailed is counted when the row could not be written after its retries or was dropped while closing
+	RowsFailed = "failed"
+)
+
+var (
+	FetchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name: "fetch_duration_seconds",
+		Help: "Duration of the fetches of each source.",
+		Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"source", "result"})
+
+	Fetches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name: "fetches_total",
+		Help: "Fetches of each source by result.",
+	}, []string{"source", "result"})
+
+	FetchErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name: "fetch_errors_total",
+		Help: "Failed fetches of each source by error class.",
+	}, []string{"source", "class"})
+
+	Rows = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name: "rows_total",
+		Help: "Rows saved to, failed to save to or deleted from each table by result.",
+	}, []string{"table", "result"})
+
+	FlushDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name: "db_flush_duration_seconds",
+		Help: "Duration of the batched write transactions by result.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"result"})
+
+	FlushRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name: "db_flush_retries_total",
+		Help: "Batched write transactions retried after an error.",
+	})
+
+	Price = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name: "price",
+		Help: "Latest price of each source.",
+	}, []string{"source", "product"})
+
+	PriceTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name: "price_timestamp_seconds",
+		Help: "Timestamp of the latest price of each source.",
+	}, []string{"source", "product"})
+
+	QueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name: "db_query_duration_seconds",
+		Help: "Duration of the db queries of each route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route"})
+
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name: "http_request_duration_seconds",
+		Help: "Duration of the http requests of each route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name: "http_requests_total",
+		Help: "Http requests of each route by status.",
+	}, []string{"method", "route", "status"})
+)
+
+func init() {
+	prometheus.MustRegister(FetchDuration, Fetches, FetchErrors, Rows, FlushDuration, FlushRetries, Price, PriceTimestamp, QueryDuration, RequestDuration, Requests)
+}
+
+// ErrorClass groups the fetch errors to keep the label values bounded
+func ErrorClass(err error) string {
+	switch e := err.(type) {
+	case net.Error:
+		if e.Timeout() {
+			return "timeout"
+		}
+		return "network"
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		return "decode"
+	case *strconv.NumError, *time.ParseError:
+		return "parse"
+	}
+	return "other"
+}
+
+// ObserveFetch records a fetch of the source started at start
+func ObserveFetch(source string, start time.Time, err error) {
+	result := ResultSuccess
+	if err != nil {
+		result = ResultFailure
+		FetchErrors.WithLabelValues(source, ErrorClass(err)).Inc()
+	}
+
+	FetchDuration.WithLabelValues(source, result).Observe(time.Since(start).Seconds())
+	Fetches.WithLabelValues(source, result).Inc()
+}
+
+func ObserveRow(table string, result string) {
+	Rows.WithLabelValues(table, result).Inc()
+}
+
+func ObserveDeleted(table string, count int64) {
+	Rows.WithLabelValues(table, RowsDeleted).Add(float64(count))
+}
+
+func ObserveFlush(start time.Time, err error) {
+	result := ResultSuccess
+	if err != nil {
+		result = ResultFailure
+	}
+
+	FlushDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+}
+
+func ObserveFlushRetry() {
+	FlushRetries.Inc()
+}
+
+func SetPrice(source string, product string, timestamp int64, price float64) {
+	Price.WithLabelValues(source, product).Set(price)
+	PriceTimestamp.WithLabelValues(source, product).Set(float64(timestamp))
+}
+
+// ObserveQuery records the db queries of the route started at start
+func ObserveQuery(route string, start time.Time) {
+	QueryDuration.WithLabelValues(route).Observe(time.Since(start).Seconds())
+}
+
+// Route is the registered path of the request, unmatched requests share one value to keep the label values bounded
+func Route(c *gin.Context) string {
+	route := c.FullPath()
+	if route == "" {
+		return "unmatched"
+	}
+	return route
+}
+
+// Middleware records the duration and status of the requests
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		status := strconv.Itoa(c.Writer.Status())
+		route := Route(c)
+
+		RequestDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+		Requests.WithLabelValues(c.Request.Method, route, status).Inc()
+	}
+}
+
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
@@ -0,0 +1,252 @@
+package retention
+
+import (
+	"candle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"metrics"
+	"tick"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// day is the alignment of the cutoff, the rollup intervals should divide it so no candle is rolled up from a part of its ticks
+const day = 86400
+
+// batchPause is the pause between two delete batches to let the writers in
+const batchPause = time.Millisecond * 100
+
+// Policy keeps the raw ticks of a table for KeepDays days, the older ticks are rolled up to the Rollups candles like 1m and 1h before they are deleted
+type Policy struct {
+	Source string `json:"source"`
+	Product string `json:"product"`
+	KeepDays int64 `json:"keepDays"`
+	Rollups []string `json:"rollups"`
+}
+
+// Validate lists all the problems of the policy at once
+func (p Policy) Validate() error {
+	var problems []string
+
+	_, err := tick.FindTable(p.Source, p.Product)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if p.KeepDays < 1 {
+		problems = append(problems, "keepDays should be positive")
+	}
+
+	for _, v := range p.Rollups {
+		interval, err := candle.ParseInterval(v)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+
+		if day%interval != 0 {
+			problems = append(problems, fmt.Sprintf("rollup %v should divide a day", v))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(fmt.Sprintf("invalid retention policy %v %v: %v", p.Source, p.Product, problems))
+	}
+
+	return nil
+}
+
+// Config runs the compactor every Interval, it deletes at most BatchSize rows at once and only reports what would be removed when DryRun
+type Config struct {
+	Enabled bool
+	Interval string
+	BatchSize int64
+	DryRun bool
+	Policies []Policy
+}
+
+// Validate lists all the problems of the config at once
+func (c Config) Validate() error {
+	var problems []string
+
+	interval, err := time.ParseDuration(c.Interval)
+	if err != nil || interval <= 0 {
+		problems = append(problems, "interval should be a positive duration like 1h")
+	}
+
+	if c.BatchSize < 1 {
+		problems = append(problems, "batchSize should be positive")
+	}
+
+	tables := make(map[string]bool)
+	for _, p := range c.Policies {
+		err = p.Validate()
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+
+		key := fmt.Sprintf("%v/%v", p.Source, p.Product)
+		if tables[key] {
+			problems = append(problems, fmt.Sprintf("duplicated policy: %v", key))
+		}
+		tables[key] = true
+	}
+
+	if len(problems) > 0 {
+		return errors.New(fmt.Sprintf("invalid retention: %v", problems))
+	}
+
+	return nil
+}
+
+// Report is what a compaction removed, or would remove in a dry run. Rows are the raw ticks before Cutoff between Oldest and Newest,
+// Candles are the number of candles rolled up by interval
+type Report struct {
+	Source string `json:"source"`
+	Product string `json:"product"`
+	Cutoff int64 `json:"cutoff"`
+	Rows int64 `json:"rows"`
+	Oldest int64 `json:"oldest"`
+	Newest int64 `json:"newest"`
+	Candles map[string]int64 `json:"candles"`
+	DryRun bool `json:"dryRun"`
+}
+
+// Compact rolls up and deletes the ticks of the policy older than KeepDays days before now, the cutoff is aligned to the start of a day
+func Compact(db *sql.DB, policy Policy, now int64, batchSize int64, dryRun bool) (Report, error) {
+	report := Report{
+		Source: policy.Source,
+		Product: policy.Product,
+		Cutoff: candle.BucketStart(now-policy.KeepDays*day, day),
+		Candles: make(map[string]int64),
+		DryRun: dryRun,
+	}
+
+	logger := log.WithFields(log.Fields{"source": policy.Source, "product": policy.Product})
+
+	table, err := tick.FindTable(policy.Source, policy.Product)
+	if err != nil {
+		return report, err
+	}
+
+	row := db.QueryRow(fmt.Sprintf("SELECT COUNT(*),IFNULL(MIN(`log_time`),0),IFNULL(MAX(`log_time`),0) FROM `%v` WHERE `log_time`<?", table.Name), report.Cutoff)
+	err = row.Scan(&report.Rows, &report.Oldest, &report.Newest)
+	if err != nil {
+		logger.WithError(err).Error("count expired ticks error")
+		return report, err
+	}
+
+	if report.Rows == 0 {
+		return report, nil
+	}
+
+	if dryRun {
+		for _, v := range policy.Rollups {
+			interval, err := candle.ParseInterval(v)
+			if err != nil {
+				return report, err
+			}
+
+			var count int64
+
+			row := db.QueryRow(fmt.Sprintf("SELECT COUNT(DISTINCT `log_time`/?) FROM `%v` WHERE `log_time`<?", table.Name), interval, report.Cutoff)
+			err = row.Scan(&count)
+			if err != nil {
+				logger.WithError(err).Error("count rollup candles error")
+				return report, err
+			}
+
+			report.Candles[v] = count
+		}
+
+		return report, nil
+	}
+
+	// Rows becomes the number of ticks deleted, the ticks saved meanwhile before the oldest one are left to the next compaction
+	report.Rows = 0
+
+	for start := report.Oldest; start < report.Cutoff; {
+		end, err := batchEnd(db, table, start, report.Cutoff, batchSize)
+		if err != nil {
+			logger.WithError(err).Error("find batch end error")
+			return report, err
+		}
+
+		deleted, err := rollupAndDelete(db, table, policy.Rollups, start, end, report.Candles)
+		if err != nil {
+			logger.WithError(err).Error("compact ticks error")
+			return report, err
+		}
+		report.Rows += deleted
+
+		start = end + 1
+		if start < report.Cutoff {
+			time.Sleep(batchPause)
+		}
+	}
+
+	return report, nil
+}
+
+// batchEnd is the time of the batchSize-th tick from tsStart, the batch ends before the cutoff when fewer ticks are left
+func batchEnd(db *sql.DB, table tick.Table, tsStart int64, cutoff int64, batchSize int64) (int64, error) {
+	var end int64
+
+	row := db.QueryRow(fmt.Sprintf("SELECT `log_time` FROM `%v` WHERE `log_time`>=? AND `log_time`<? ORDER BY `log_time` ASC LIMIT 1 OFFSET ?", table.Name), tsStart, cutoff, batchSize-1)
+	err := row.Scan(&end)
+	if err == sql.ErrNoRows {
+		return cutoff - 1, nil
+	}
+
+	return end, err
+}
+
+// rollupAndDelete rolls up the ticks between tsStart and tsEnd to the candles and deletes them in a transaction,
+// so only the ticks rolled up are deleted even when late ticks are saved meanwhile. The candles rolled up are added to candles by rollup
+func rollupAndDelete(db *sql.DB, table tick.Table, rollups []string, tsStart int64, tsEnd int64, candles map[string]int64) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	counts := make(map[string]int64)
+	for _, v := range rollups {
+		interval, err := candle.ParseInterval(v)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+
+		counts[v], err = candle.Rollup(tx, table, interval, tsStart, tsEnd)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	res, err := tx.Exec(fmt.Sprintf("DELETE FROM `%v` WHERE `log_time` BETWEEN ? AND ?", table.Name), tsStart, tsEnd)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	affectedRows, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	for v, count := range counts {
+		candles[v] += count
+	}
+	metrics.ObserveDeleted(table.Name, affectedRows)
+
+	return affectedRows, nil
+}
@@ -0,0 +1,170 @@
+package retention
+
+import (
+	"brti"
+	"candle"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"tick"
+	"util"
+)
+
+var testPolicy = Policy{Source: tick.SourceBrti, Product: tick.ProductBtcUsd, KeepDays: 1, Rollups: []string{"1m"}}
+
+// now compacts the ticks before day 9
+const now = 10*day + 100
+
+func openTestDB(t *testing.T) *sql.DB {
+	db, err := util.OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	brti.InitDb(db)
+	candle.InitDb(db)
+
+	return db
+}
+
+func saveTicks(t *testing.T, db *sql.DB, ticks ...tick.Tick) {
+	for _, v := range ticks {
+		_, err := db.Exec("INSERT INTO `brti_logs`(`log_time`,`log_price`) VALUES(?,?)", v.Timestamp, v.Price)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func storedCandle(t *testing.T, db *sql.DB, ts int64) candle.Candle {
+	table, _ := tick.FindTable(tick.SourceBrti, tick.ProductBtcUsd)
+
+	candles, err := candle.FindMaterialized(db, table, 60, ts, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candles) != 1 {
+		t.Fatalf("%v candles at %v, want 1", len(candles), ts)
+	}
+	return candles[0]
+}
+
+func countTicks(t *testing.T, db *sql.DB) int64 {
+	var count int64
+	err := db.QueryRow("SELECT COUNT(*) FROM `brti_logs`").Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestCompactRollsUpByBatches(t *testing.T) {
+	db := openTestDB(t)
+	table, _ := tick.FindTable(tick.SourceBrti, tick.ProductBtcUsd)
+
+	bucket := int64(8 * day)
+	saveTicks(t, db,
+		tick.Tick{Timestamp: bucket, Price: 10},
+		tick.Tick{Timestamp: bucket + 10, Price: 15},
+		tick.Tick{Timestamp: bucket + 20, Price: 5},
+		tick.Tick{Timestamp: bucket + 30, Price: 12},
+		tick.Tick{Timestamp: bucket + 70, Price: 20},
+		tick.Tick{Timestamp: 9*day + 5, Price: 30})
+
+	// the candle materialized from all the ticks is replaced by the rollup, not added to
+	_, err := candle.Materialize(db, table, 60, bucket, bucket+59)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the batches split the first candle, its parts are merged
+	report, err := Compact(db, testPolicy, now, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Cutoff != 9*day || report.Rows != 5 {
+		t.Errorf("Compact() = %+v, want 5 rows deleted before day 9", report)
+	}
+
+	if c := storedCandle(t, db, bucket); c != (candle.Candle{Time: bucket, Open: 10, High: 15, Low: 5, Close: 12, Count: 4}) {
+		t.Errorf("rolled up %+v, want the candle of all its ticks", c)
+	}
+	if c := storedCandle(t, db, bucket+60); c != (candle.Candle{Time: bucket + 60, Open: 20, High: 20, Low: 20, Close: 20, Count: 1}) {
+		t.Errorf("rolled up %+v, want the candle of the single tick", c)
+	}
+
+	if n := countTicks(t, db); n != 1 {
+		t.Errorf("%v ticks left, want the one after the cutoff", n)
+	}
+}
+
+func TestCompactMergesLateTicks(t *testing.T) {
+	db := openTestDB(t)
+	table, _ := tick.FindTable(tick.SourceBrti, tick.ProductBtcUsd)
+
+	bucket := int64(8 * day)
+	saveTicks(t, db, tick.Tick{Timestamp: bucket, Price: 10}, tick.Tick{Timestamp: bucket + 10, Price: 15})
+
+	_, err := Compact(db, testPolicy, now, 100, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a late tick of the candle rolled up is resampled alone, materializing it keeps the rolled up candle
+	saveTicks(t, db, tick.Tick{Timestamp: bucket + 30, Price: 3})
+
+	_, err = candle.Materialize(db, table, 60, bucket, bucket+59)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := storedCandle(t, db, bucket); c != (candle.Candle{Time: bucket, Open: 10, High: 15, Low: 10, Close: 15, Count: 2}) {
+		t.Errorf("materialized %+v, want the rolled up candle kept", c)
+	}
+
+	// the next compaction merges it
+	report, err := Compact(db, testPolicy, now, 100, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Rows != 1 || report.Candles["1m"] != 1 {
+		t.Errorf("Compact() = %+v, want the late tick rolled up", report)
+	}
+
+	if c := storedCandle(t, db, bucket); c != (candle.Candle{Time: bucket, Open: 10, High: 15, Low: 3, Close: 3, Count: 3}) {
+		t.Errorf("merged %+v, want the open kept, the late close, the lowest low and the counts added up", c)
+	}
+
+	if n := countTicks(t, db); n != 0 {
+		t.Errorf("%v ticks left, want none", n)
+	}
+}
+
+func TestCompactDryRun(t *testing.T) {
+	db := openTestDB(t)
+
+	saveTicks(t, db, tick.Tick{Timestamp: 8 * day, Price: 10}, tick.Tick{Timestamp: 8*day + 70, Price: 20})
+
+	report, err := Compact(db, testPolicy, now, 100, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Rows != 2 || report.Candles["1m"] != 2 {
+		t.Errorf("Compact() = %+v, want 2 rows and 2 candles", report)
+	}
+
+	if n := countTicks(t, db); n != 2 {
+		t.Errorf("%v ticks left, the dry run should not delete", n)
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	if err := testPolicy.Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+
+	policy := Policy{Source: "kraken", Product: tick.ProductBtcUsd, KeepDays: 0, Rollups: []string{"7m"}}
+	if err := policy.Validate(); err == nil {
+		t.Error("Validate() should reject an unknown source, no keepDays and a rollup not dividing a day")
+	}
+}
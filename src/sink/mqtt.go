@@ -0,0 +1,164 @@
+package sink
+
+import (
+	"bus"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+)
+
+type MQTTConfig struct {
+	// Broker is like tcp://127.0.0.1:1883 or ssl://127.0.0.1:8883, the sink is disabled when empty
+	Broker string
+	ClientID string
+	Username string
+	Password string
+	QoS byte
+	// TopicTemplate is used for the channels not in Topics, like prices/{source}/{product}
+	TopicTemplate string
+	// Topics maps a channel to its retained topic, like gdax/btcusd: prices/gdax/BTC-USD
+	Topics map[string]string
+	CAFile string
+	CertFile string
+	KeyFile string
+	InsecureSkipVerify bool
+}
+
+// maxConnectDelay is the max delay between the attempts to connect to the mqtt broker
+const maxConnectDelay = time.Minute
+
+// MQTTSink publishes the latest price of each source to a retained topic
+type MQTTSink struct {
+	config MQTTConfig
+	client mqtt.Client
+}
+
+func newTLSConfig(config MQTTConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+
+	if config.CAFile != "" {
+		ca, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("invalid mqtt ca file")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.CertFile != "" || config.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// NewMQTTSink connects to the broker in the background, the ticks are skipped until it is connected
+func NewMQTTSink(config MQTTConfig) (*MQTTSink, error) {
+	if config.QoS > 2 {
+		return nil, errors.New("mqtt qos should be 0, 1 or 2")
+	}
+
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(config.Broker)
+	opts.SetClientID(config.ClientID)
+	opts.SetUsername(config.Username)
+	opts.SetPassword(config.Password)
+	opts.SetAutoReconnect(true)
+	opts.SetConnectTimeout(time.Second * 5)
+
+	if strings.HasPrefix(config.Broker, "ssl://") || strings.HasPrefix(config.Broker, "tls://") || config.CAFile != "" || config.CertFile != "" {
+		tlsConfig, err := newTLSConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	s := &MQTTSink{config, mqtt.NewClient(opts)}
+
+	go s.connect()
+
+	return s, nil
+}
+
+// connect retries with backoff until the broker is first reached, the client reconnects by itself after
+func (s *MQTTSink) connect() {
+	delay := time.Second
+
+	for {
+		token := s.client.Connect()
+		token.Wait()
+
+		if token.Error() == nil {
+			log.WithField("broker", s.config.Broker).Info("connected to mqtt")
+			return
+		}
+
+		log.WithFields(log.Fields{"broker": s.config.Broker, "retry": delay.String()}).WithError(token.Error()).Error("connect mqtt error")
+
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > maxConnectDelay {
+			delay = maxConnectDelay
+		}
+	}
+}
+
+func (s *MQTTSink) Topic(e bus.Event) string {
+	if topic, ok := s.config.Topics[e.Channel()]; ok {
+		return topic
+	}
+
+	return strings.NewReplacer("{source}", e.Source, "{product}", e.Product).Replace(s.config.TopicTemplate)
+}
+
+// Run publishes the ticks of the subscription until it is closed, older ticks never overwrite the retained latest price
+func (s *MQTTSink) Run(subscription *bus.Subscription) {
+	latest := make(map[string]int64)
+
+	for e := range subscription.C {
+		if e.Timestamp <= latest[e.Channel()] {
+			continue
+		}
+		latest[e.Channel()] = e.Timestamp
+
+		if !s.client.IsConnected() {
+			// only the latest price matters, the retained message is refreshed by the next tick after reconnecting
+			continue
+		}
+
+		data, err := json.Marshal(e)
+		if err != nil {
+			log.WithError(err).Error("encode mqtt message error")
+			continue
+		}
+
+		topic := s.Topic(e)
+
+		token := s.client.Publish(topic, s.config.QoS, true, data)
+		if !token.WaitTimeout(time.Second * 5) {
+			log.WithField("topic", topic).Error("publish mqtt message timeout")
+			continue
+		}
+
+		if token.Error() != nil {
+			log.WithField("topic", topic).WithError(token.Error()).Error("publish mqtt message error")
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+)
+
+// Publisher delivers a message to a subject of NATS or a topic of Kafka
+type Publisher interface {
+	Publish(subject string, data []byte) error
+	Close() error
+}
+
+type natsPublisher struct {
+	conn *nats.Conn
+}
+
+func NewNatsPublisher(url string) (Publisher, error) {
+	conn, err := nats.Connect(url, nats.MaxReconnects(-1), nats.RetryOnFailedConnect(true))
+	if err != nil {
+		return nil, err
+	}
+
+	return &natsPublisher{conn}, nil
+}
+
+// Publish fails while disconnected instead of letting the client buffer the message, so it could be spooled on disk
+func (p *natsPublisher) Publish(subject string, data []byte) error {
+	if !p.conn.IsConnected() {
+		return errors.New("nats not connected")
+	}
+
+	err := p.conn.Publish(subject, data)
+	if err != nil {
+		return err
+	}
+
+	return p.conn.FlushTimeout(time.Second * 5)
+}
+
+func (p *natsPublisher) Close() error {
+	p.conn.Close()
+	return nil
+}
+
+type kafkaPublisher struct {
+	brokers []string
+
+	mutex sync.Mutex
+	writers map[string]*kafka.Writer
+}
+
+func NewKafkaPublisher(brokers []string) (Publisher, error) {
+	if len(brokers) == 0 {
+		return nil, errors.New("kafka brokers not configured")
+	}
+
+	return &kafkaPublisher{brokers: brokers, writers: make(map[string]*kafka.Writer)}, nil
+}
+
+func (p *kafkaPublisher) writer(topic string) *kafka.Writer {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	w, ok := p.writers[topic]
+	if !ok {
+		w = kafka.NewWriter(kafka.WriterConfig{
+			Brokers: p.brokers,
+			Topic: topic,
+			Balancer: &kafka.Hash{},
+			MaxAttempts: 1,
+			BatchTimeout: time.Millisecond * 10,
+		})
+		p.writers[topic] = w
+	}
+
+	return w
+}
+
+func (p *kafkaPublisher) Publish(topic string, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	return p.writer(topic).WriteMessages(ctx, kafka.Message{Key: []byte(topic), Value: data})
+}
+
+func (p *kafkaPublisher) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var result error
+	for _, w := range p.writers {
+		err := w.Close()
+		if err != nil {
+			result = err
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,205 @@
+package sink
+
+import (
+	"bus"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	TypeNats = "nats"
+	TypeKafka = "kafka"
+)
+
+type Config struct {
+	// Type is nats or kafka, the sink is disabled when empty
+	Type string
+	URL string
+	Brokers []string
+	// SubjectTemplate maps a source and product to a subject or topic, like prices.{source}.{product}
+	SubjectTemplate string
+	// Subjects overrides the template for some channels, like brti/btcusd: cme.brti
+	Subjects map[string]string
+	SpoolPath string
+	SpoolMaxBytes int64
+	Retries int
+}
+
+const (
+	// retryDelay is the delay before the first drain of a message which failed to publish, doubled for Retries times,
+	// then the spool is drained every drainInterval doubled up to maxDrainInterval while the broker is unavailable
+	retryDelay = time.Millisecond * 100
+	drainInterval = time.Second * 5
+	maxDrainInterval = time.Minute
+)
+
+// Sink forwards the ticks to the message bus, messages are spooled on disk while the broker is unavailable
+type Sink struct {
+	config Config
+	publisher Publisher
+	spool *Spool
+	drainInterval time.Duration
+	// spooled wakes up the drain when a message failed to publish
+	spooled chan struct{}
+}
+
+func NewPublisher(config Config) (Publisher, error) {
+	switch config.Type {
+	case TypeNats:
+		return NewNatsPublisher(config.URL)
+	case TypeKafka:
+		return NewKafkaPublisher(config.Brokers)
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown sink type: %v", config.Type))
+	}
+}
+
+func New(config Config, publisher Publisher, spool *Spool) *Sink {
+	return &Sink{config, publisher, spool, drainInterval, make(chan struct{}, 1)}
+}
+
+func (s *Sink) Subject(e bus.Event) string {
+	if subject, ok := s.config.Subjects[e.Channel()]; ok {
+		return subject
+	}
+
+	return strings.NewReplacer("{source}", e.Source, "{product}", e.Product).Replace(s.config.SubjectTemplate)
+}
+
+// Run forwards the ticks of the subscription until it is closed, each tick is only forwarded once.
+// The spool is drained by its own goroutine so the subscription is still read while a long drain is running
+func (s *Sink) Run(subscription *bus.Subscription) {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		s.runDrain(stop)
+		close(done)
+	}()
+
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	latest := make(map[string]int64)
+
+	for e := range subscription.C {
+		if e.Timestamp <= latest[e.Channel()] {
+			continue
+		}
+		latest[e.Channel()] = e.Timestamp
+
+		data, err := json.Marshal(e)
+		if err != nil {
+			log.WithError(err).Error("encode sink message error")
+			continue
+		}
+
+		s.deliver(s.Subject(e), data)
+	}
+}
+
+// runDrain drains the spool until stop, soon after a message failed to publish then backing off while the broker is unavailable
+func (s *Sink) runDrain(stop <-chan struct{}) {
+	attempt := 0
+
+	timer := time.NewTimer(s.drainInterval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-s.spooled:
+			attempt = 0
+		case <-timer.C:
+			if s.drain() {
+				attempt = 0
+				timer.Reset(s.drainInterval)
+				continue
+			}
+			attempt++
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(s.drainDelay(attempt))
+	}
+}
+
+// drainDelay is the delay before the next drain after the given failed ones
+func (s *Sink) drainDelay(attempt int) time.Duration {
+	if attempt < s.config.Retries {
+		return retryDelay << uint(attempt)
+	}
+
+	delay := s.drainInterval
+	for i := s.config.Retries; i < attempt && delay < maxDrainInterval; i++ {
+		delay *= 2
+	}
+	if delay > maxDrainInterval {
+		delay = maxDrainInterval
+	}
+
+	return delay
+}
+
+// drain delivers the spooled messages, including the ones appended meanwhile, it tells whether the spool is empty after
+func (s *Sink) drain() bool {
+	for !s.spool.Empty() {
+		delivered, err := s.spool.Drain(s.publisher.Publish)
+		if err != nil {
+			log.WithError(err).Error("drain sink spool error")
+		}
+
+		if delivered > 0 {
+			log.WithField("count", delivered).Info("delivered spooled sink messages")
+		}
+
+		if err != nil || delivered == 0 {
+			break
+		}
+	}
+
+	return s.spool.Empty()
+}
+
+// deliver publishes the message once and spools it when the broker is unavailable, the drain retries it with backoff.
+// While messages are spooled the new ones are appended after them to keep the order, they are delivered by the next drain
+func (s *Sink) deliver(subject string, data []byte) {
+	if s.spool.Empty() {
+		err := s.publisher.Publish(subject, data)
+		if err == nil {
+			return
+		}
+
+		log.WithField("subject", subject).WithError(err).Error("publish sink message error, spool")
+
+		err = s.spool.Append(subject, data)
+		if err != nil {
+			log.WithField("subject", subject).WithError(err).Error("drop sink message")
+			return
+		}
+
+		select {
+		case s.spooled <- struct{}{}:
+		default:
+		}
+		return
+	}
+
+	err := s.spool.Append(subject, data)
+	if err != nil {
+		log.WithField("subject", subject).WithError(err).Error("drop sink message")
+	}
+}
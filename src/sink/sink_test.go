@@ -0,0 +1,274 @@
+package sink
+
+import (
+	"bufio"
+	"bus"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// natsServer is a local broker speaking enough of the NATS protocol to receive the published messages
+type natsServer struct {
+	listener net.Listener
+
+	mutex sync.Mutex
+	messages []natsMessage
+}
+
+type natsMessage struct {
+	subject string
+	data string
+}
+
+func startNatsServer(t *testing.T) *natsServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &natsServer{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *natsServer) URL() string {
+	return "nats://" + s.listener.Addr().String()
+}
+
+func (s *natsServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	addr := s.listener.Addr().(*net.TCPAddr)
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"test\",\"version\":\"2.2.0\",\"host\":\"127.0.0.1\",\"port\":%v,\"max_payload\":1048576,\"proto\":1}\r\n", addr.Port)
+
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "PING":
+			io.WriteString(conn, "PONG\r\n")
+		case "PUB":
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil {
+				return
+			}
+
+			payload := make([]byte, size+2)
+			_, err = io.ReadFull(reader, payload)
+			if err != nil {
+				return
+			}
+
+			s.mutex.Lock()
+			s.messages = append(s.messages, natsMessage{fields[1], string(payload[:size])})
+			s.mutex.Unlock()
+		}
+	}
+}
+
+func (s *natsServer) received() []natsMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]natsMessage(nil), s.messages...)
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(time.Second * 5)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func tickEvent(source string, ts int64, price float64) bus.Event {
+	return bus.Event{Type: bus.TypeTick, Source: source, Product: "btcusd", Timestamp: ts, Price: price}
+}
+
+func TestSinkPublishesToNats(t *testing.T) {
+	server := startNatsServer(t)
+
+	publisher, err := NewNatsPublisher(server.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+
+	config := Config{Type: TypeNats, SubjectTemplate: "prices.{source}.{product}", Subjects: map[string]string{"brti/btcusd": "cme.brti"}}
+	s := New(config, publisher, newTestSpool(t))
+
+	eventBus := bus.New()
+	subscription := eventBus.Subscribe("sink", 16, []string{bus.TypeTick}, []string{bus.AllChannels})
+	go s.Run(subscription)
+
+	eventBus.Publish(tickEvent("brti", 100, 1))
+	eventBus.Publish(tickEvent("brti", 99, 1))
+	eventBus.Publish(tickEvent("gdax", 100, 2))
+
+	waitFor(t, func() bool { return len(server.received()) == 2 })
+
+	eventBus.Unsubscribe(subscription)
+	close(subscription.C)
+
+	messages := server.received()
+	if messages[0].subject != "cme.brti" || messages[1].subject != "prices.gdax.btcusd" {
+		t.Errorf("published to %v, want cme.brti then prices.gdax.btcusd", messages)
+	}
+
+	var e bus.Event
+	err = json.Unmarshal([]byte(messages[1].data), &e)
+	if err != nil || e.Timestamp != 100 || e.Price != 2 {
+		t.Errorf("published %v, %v", messages[1].data, err)
+	}
+}
+
+func TestSinkSpoolsWhileBrokerUnavailable(t *testing.T) {
+	r := &recorder{fail: map[string]bool{}}
+	spool := newTestSpool(t)
+
+	s := New(Config{SubjectTemplate: "prices"}, r, spool)
+	s.drainInterval = time.Millisecond * 10
+
+	data := func(ts int64) string {
+		b, _ := json.Marshal(tickEvent("brti", ts, 1))
+		return string(b)
+	}
+
+	// the first message fails and is spooled, the next one is appended after it without trying the broker
+	r.fail[data(1)] = true
+	s.deliver("prices", []byte(data(1)))
+	s.deliver("prices", []byte(data(2)))
+
+	if len(r.published) != 0 || spool.Empty() {
+		t.Fatalf("published %v, both messages should be spooled", r.published)
+	}
+
+	delete(r.fail, data(1))
+
+	eventBus := bus.New()
+	subscription := eventBus.Subscribe("sink", 16, []string{bus.TypeTick}, []string{bus.AllChannels})
+
+	done := make(chan struct{})
+	go func() {
+		s.Run(subscription)
+		close(done)
+	}()
+
+	waitFor(t, spool.Empty)
+
+	eventBus.Unsubscribe(subscription)
+	close(subscription.C)
+	<-done
+
+	if len(r.published) != 2 || r.published[0] != data(1) || r.published[1] != data(2) {
+		t.Errorf("published %v, want the spooled messages in order", r.published)
+	}
+}
+
+// gatedPublisher fails until opened, the publishes of the drain block on gate meanwhile
+type gatedPublisher struct {
+	gate chan struct{}
+
+	mutex sync.Mutex
+	published []int64
+	failed bool
+}
+
+func (p *gatedPublisher) Publish(subject string, data []byte) error {
+	p.mutex.Lock()
+	if !p.failed {
+		p.failed = true
+		p.mutex.Unlock()
+		return errors.New("broker unavailable")
+	}
+	p.mutex.Unlock()
+
+	<-p.gate
+
+	var e bus.Event
+	json.Unmarshal(data, &e)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.published = append(p.published, e.Timestamp)
+	return nil
+}
+
+func (p *gatedPublisher) Close() error {
+	return nil
+}
+
+func (p *gatedPublisher) count() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.published)
+}
+
+func TestSinkReadsSubscriptionWhileDraining(t *testing.T) {
+	p := &gatedPublisher{gate: make(chan struct{})}
+	spool := newTestSpool(t)
+
+	s := New(Config{SubjectTemplate: "prices", Retries: 1}, p, spool)
+
+	eventBus := bus.New()
+	subscription := eventBus.Subscribe("sink", 2, []string{bus.TypeTick}, []string{bus.AllChannels})
+
+	done := make(chan struct{})
+	go func() {
+		s.Run(subscription)
+		close(done)
+	}()
+
+	// the first tick fails and is spooled, the drain starting soon after blocks on the broker
+	eventBus.Publish(tickEvent("brti", 1, 1))
+	time.Sleep(time.Millisecond * 300)
+
+	// the ticks published meanwhile are still read and spooled rather than dropped by the bus
+	for ts := int64(2); ts <= 20; ts++ {
+		eventBus.Publish(tickEvent("brti", ts, 1))
+		time.Sleep(time.Millisecond * 5)
+	}
+
+	close(p.gate)
+	waitFor(t, func() bool { return p.count() == 20 })
+
+	eventBus.Unsubscribe(subscription)
+	close(subscription.C)
+	<-done
+
+	for i, ts := range p.published {
+		if ts != int64(i+1) {
+			t.Fatalf("published %v, want the ticks 1 to 20 in order", p.published)
+		}
+	}
+}
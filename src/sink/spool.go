@@ -0,0 +1,190 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// spoolMessage is a line of the spool file
+type spoolMessage struct {
+	Subject string `json:"subject"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Spool buffers the messages on disk while the broker is unavailable, up to maxBytes.
+// The messages can be appended while the spool is drained, mutex is only held to append and to remove the drained lines
+type Spool struct {
+	path string
+	maxBytes int64
+
+	drainMutex sync.Mutex
+	mutex sync.Mutex
+	size int64
+}
+
+func NewSpool(path string, maxBytes int64) (*Spool, error) {
+	s := &Spool{path: path, maxBytes: maxBytes}
+
+	info, err := os.Stat(path)
+	if err == nil {
+		s.size = info.Size()
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *Spool) Empty() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.size == 0
+}
+
+func (s *Spool) Append(subject string, data []byte) error {
+	line, err := json.Marshal(spoolMessage{subject, data})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.size+int64(len(line)) > s.maxBytes {
+		return errors.New("spool is full")
+	}
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	_, err = f.Write(line)
+	if err != nil {
+		return err
+	}
+
+	s.size += int64(len(line))
+
+	return nil
+}
+
+// Drain publishes the messages spooled so far in order, the ones not delivered and the ones appended meanwhile are kept for the next drain.
+// Only the lines read up to the first failure are removed, so a message is never delivered twice even when the file can not be read through
+func (s *Spool) Drain(publish func(subject string, data []byte) error) (int, error) {
+	s.drainMutex.Lock()
+	defer s.drainMutex.Unlock()
+
+	s.mutex.Lock()
+	size := s.size
+	s.mutex.Unlock()
+
+	if size == 0 {
+		return 0, nil
+	}
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		return 0, err
+	}
+
+	defer f.Close()
+
+	// offset is the end of the lines delivered or skipped
+	var offset int64
+	delivered := 0
+
+	// the lines appended after size are left to the next drain
+	scanner := bufio.NewScanner(io.LimitReader(f, size))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		var m spoolMessage
+
+		err = json.Unmarshal(line, &m)
+		if err != nil {
+			log.WithField("line", string(line)).Warn("ignore invalid spool line")
+			offset += int64(len(line)) + 1
+			continue
+		}
+
+		err = publish(m.Subject, m.Data)
+		if err != nil {
+			break
+		}
+
+		delivered++
+		offset += int64(len(line)) + 1
+	}
+
+	scanErr := scanner.Err()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err = s.truncate(f, offset)
+	if err != nil {
+		return delivered, err
+	}
+
+	return delivered, scanErr
+}
+
+// truncate removes the lines of the spool file before offset, the mutex should be held
+func (s *Spool) truncate(f *os.File, offset int64) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if offset >= info.Size() {
+		err = os.Remove(s.path)
+		if err != nil {
+			return err
+		}
+
+		s.size = 0
+		return nil
+	}
+
+	if offset == 0 {
+		return nil
+	}
+
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	remaining, err := io.Copy(tmp, f)
+	tmp.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	err = os.Rename(tmpPath, s.path)
+	if err != nil {
+		return err
+	}
+
+	s.size = remaining
+	return nil
+}
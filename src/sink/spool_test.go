@@ -0,0 +1,121 @@
+package sink
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// recorder publishes to a list, failing the messages listed in fail
+type recorder struct {
+	published []string
+	fail map[string]bool
+}
+
+func (r *recorder) Publish(subject string, data []byte) error {
+	if r.fail[string(data)] {
+		return errors.New("broker unavailable")
+	}
+
+	r.published = append(r.published, string(data))
+	return nil
+}
+
+func (r *recorder) Close() error {
+	return nil
+}
+
+func newTestSpool(t *testing.T) *Spool {
+	spool, err := NewSpool(filepath.Join(t.TempDir(), "sink.spool"), 1<<30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return spool
+}
+
+func appendMessages(t *testing.T, spool *Spool, messages ...string) {
+	for _, v := range messages {
+		err := spool.Append("prices", []byte(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSpoolDrainKeepsUndelivered(t *testing.T) {
+	spool := newTestSpool(t)
+	appendMessages(t, spool, "1", "2", "3")
+
+	r := &recorder{fail: map[string]bool{"2": true}}
+
+	delivered, err := spool.Drain(r.Publish)
+	if err != nil || delivered != 1 {
+		t.Fatalf("Drain() = %v, %v, want 1 delivered", delivered, err)
+	}
+	if spool.Empty() {
+		t.Fatal("the undelivered messages should be kept")
+	}
+
+	r.fail = nil
+
+	delivered, err = spool.Drain(r.Publish)
+	if err != nil || delivered != 2 {
+		t.Fatalf("Drain() = %v, %v, want 2 delivered", delivered, err)
+	}
+	if !spool.Empty() {
+		t.Error("the spool should be empty once all delivered")
+	}
+
+	if strings.Join(r.published, ",") != "1,2,3" {
+		t.Errorf("published %v, want 1,2,3 in order", r.published)
+	}
+}
+
+func TestSpoolDrainReadErrorDoesNotRedeliver(t *testing.T) {
+	spool := newTestSpool(t)
+	appendMessages(t, spool, "1", "2")
+
+	// a line longer than the scanner buffer stops the read after the first two messages
+	f, err := os.OpenFile(spool.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(strings.Repeat("x", 2*1024*1024) + "\n")
+	f.Close()
+
+	// the spool is opened again as after a restart, its size is read from the file
+	spool, err = NewSpool(spool.path, 1<<30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendMessages(t, spool, "3")
+
+	r := &recorder{}
+
+	for i := 0; i < 2; i++ {
+		_, err = spool.Drain(r.Publish)
+		if err == nil {
+			t.Error("Drain() should report the read error")
+		}
+	}
+
+	if strings.Join(r.published, ",") != "1,2" {
+		t.Errorf("published %v, want 1,2 only once", r.published)
+	}
+}
+
+func TestSpoolFull(t *testing.T) {
+	spool, err := NewSpool(filepath.Join(t.TempDir(), "sink.spool"), 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appendMessages(t, spool, "1")
+
+	err = spool.Append("prices", []byte(strings.Repeat("1", 64)))
+	if err == nil {
+		t.Error("Append() should fail once maxBytes is reached")
+	}
+}
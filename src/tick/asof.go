@@ -0,0 +1,155 @@
+package tick
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ModeExact = "exact"
+	ModeBefore = "before"
+	ModeAfter = "after"
+	ModeNearest = "nearest"
+)
+
+// MaxTolerance is the max distance in seconds between the requested and the matched timestamp
+const MaxTolerance = 3600
+
+var ErrNotFound = errors.New("tick not found")
+
+// Match is the tick found for a requested timestamp, the Timestamp is the one actually matched
+type Match struct {
+	Requested int64 `json:"requested"`
+	Timestamp int64 `json:"timestamp"`
+	Price float64 `json:"price"`
+	Interpolated bool `json:"interpolated"`
+	Before *Tick `json:"before,omitempty"`
+	After *Tick `json:"after,omitempty"`
+}
+
+func findOne(db *sql.DB, table Table, query string, args ...interface{}) (*Tick, error) {
+	rows, err := db.Query(fmt.Sprintf(query, table.Name), args...)
+	if err != nil {
+		log.WithFields(log.Fields{"source": table.Source, "product": table.Product}).WithError(err).Error("query as-of error")
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, nil
+	}
+
+	var t Tick
+
+	err = rows.Scan(&t.Timestamp, &t.Price)
+	if err != nil {
+		log.WithFields(log.Fields{"source": table.Source, "product": table.Product}).WithError(err).Error("read as-of error")
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+func findBefore(db *sql.DB, table Table, ts int64, tolerance int64) (*Tick, error) {
+	return findOne(db, table, "SELECT `log_time`,`log_price` FROM `%v` WHERE `log_time` BETWEEN ? AND ? ORDER BY `log_time` DESC LIMIT 1", ts-tolerance, ts)
+}
+
+func findAfter(db *sql.DB, table Table, ts int64, tolerance int64) (*Tick, error) {
+	return findOne(db, table, "SELECT `log_time`,`log_price` FROM `%v` WHERE `log_time` BETWEEN ? AND ? ORDER BY `log_time` ASC LIMIT 1", ts, ts+tolerance)
+}
+
+// FindAsOf finds the tick at, before, after or nearest to the timestamp within tolerance seconds, nearest prefers the earlier tick on a tie
+func FindAsOf(db *sql.DB, table Table, ts int64, mode string, tolerance int64) (Match, error) {
+	result := Match{Requested: ts}
+
+	if tolerance < 0 || tolerance > MaxTolerance {
+		return result, errors.New("tolerance out of range")
+	}
+
+	var found *Tick
+	var err error
+
+	switch mode {
+	case ModeExact:
+		found, err = findBefore(db, table, ts, 0)
+	case ModeBefore:
+		found, err = findBefore(db, table, ts, tolerance)
+	case ModeAfter:
+		found, err = findAfter(db, table, ts, tolerance)
+	case ModeNearest:
+		var before, after *Tick
+
+		before, err = findBefore(db, table, ts, tolerance)
+		if err != nil {
+			return result, err
+		}
+
+		after, err = findAfter(db, table, ts, tolerance)
+		if err != nil {
+			return result, err
+		}
+
+		found = before
+		if after != nil && (before == nil || after.Timestamp-ts < ts-before.Timestamp) {
+			found = after
+		}
+	default:
+		return result, errors.New(fmt.Sprintf("unknown mode: %v", mode))
+	}
+
+	if err != nil {
+		return result, err
+	}
+
+	if found == nil {
+		return result, ErrNotFound
+	}
+
+	result.Timestamp = found.Timestamp
+	result.Price = found.Price
+
+	return result, nil
+}
+
+// FindInterpolated linearly interpolates the price between the ticks around the timestamp, both ticks have to be within tolerance seconds
+func FindInterpolated(db *sql.DB, table Table, ts int64, tolerance int64) (Match, error) {
+	result := Match{Requested: ts}
+
+	if tolerance < 0 || tolerance > MaxTolerance {
+		return result, errors.New("tolerance out of range")
+	}
+
+	before, err := findBefore(db, table, ts, tolerance)
+	if err != nil {
+		return result, err
+	}
+
+	if before != nil && before.Timestamp == ts {
+		result.Timestamp = ts
+		result.Price = before.Price
+		return result, nil
+	}
+
+	after, err := findAfter(db, table, ts, tolerance)
+	if err != nil {
+		return result, err
+	}
+
+	if before == nil || after == nil {
+		return result, ErrNotFound
+	}
+
+	ratio := float64(ts-before.Timestamp) / float64(after.Timestamp-before.Timestamp)
+
+	result.Timestamp = ts
+	result.Price = before.Price + (after.Price-before.Price)*ratio
+	result.Interpolated = true
+	result.Before = before
+	result.After = after
+
+	return result, nil
+}
@@ -0,0 +1,104 @@
+package tick
+
+import (
+	"testing"
+)
+
+func TestFindAsOf(t *testing.T) {
+	db := openTestDB(t)
+	saveTicks(t, db, Tick{Timestamp: 100, Price: 10}, Tick{Timestamp: 110, Price: 20}, Tick{Timestamp: 130, Price: 50})
+
+	cases := []struct {
+		name string
+		ts int64
+		mode string
+		tolerance int64
+		timestamp int64
+		err error
+	}{
+		{"exact", 110, ModeExact, 0, 110, nil},
+		{"exact missing", 105, ModeExact, MaxTolerance, 0, ErrNotFound},
+		{"before", 105, ModeBefore, 10, 100, nil},
+		{"before includes the timestamp", 110, ModeBefore, 10, 110, nil},
+		{"before out of tolerance", 105, ModeBefore, 4, 0, ErrNotFound},
+		{"before the first tick", 99, ModeBefore, MaxTolerance, 0, ErrNotFound},
+		{"after", 105, ModeAfter, 10, 110, nil},
+		{"after the last tick", 131, ModeAfter, MaxTolerance, 0, ErrNotFound},
+		{"nearest prefers the earlier tick on a tie", 105, ModeNearest, 10, 100, nil},
+		{"nearest after", 107, ModeNearest, 10, 110, nil},
+		{"nearest only after within tolerance", 125, ModeNearest, 5, 130, nil},
+		{"nearest none within tolerance", 120, ModeNearest, 5, 0, ErrNotFound},
+	}
+
+	for _, v := range cases {
+		match, err := FindAsOf(db, brtiTable(t), v.ts, v.mode, v.tolerance)
+		if err != v.err {
+			t.Errorf("%v: FindAsOf() error = %v, want %v", v.name, err, v.err)
+			continue
+		}
+		if err == nil && (match.Requested != v.ts || match.Timestamp != v.timestamp || match.Interpolated) {
+			t.Errorf("%v: FindAsOf() = %+v, want the tick at %v", v.name, match, v.timestamp)
+		}
+	}
+
+	for _, v := range []struct {
+		name string
+		mode string
+		tolerance int64
+	}{
+		{"unknown mode", "latest", 10},
+		{"negative tolerance", ModeBefore, -1},
+		{"tolerance over the max", ModeBefore, MaxTolerance + 1},
+	} {
+		if _, err := FindAsOf(db, brtiTable(t), 105, v.mode, v.tolerance); err == nil || err == ErrNotFound {
+			t.Errorf("%v: FindAsOf() = %v, want an invalid query error", v.name, err)
+		}
+	}
+}
+
+func TestFindInterpolated(t *testing.T) {
+	db := openTestDB(t)
+	saveTicks(t, db, Tick{Timestamp: 100, Price: 10}, Tick{Timestamp: 110, Price: 20}, Tick{Timestamp: 130, Price: 50})
+
+	cases := []struct {
+		name string
+		ts int64
+		tolerance int64
+		price float64
+		interpolated bool
+		err error
+	}{
+		{"between two ticks", 120, 10, 35, true, nil},
+		{"close to the earlier tick", 101, 10, 11, true, nil},
+		{"close to the later tick", 129, 20, 48.5, true, nil},
+		{"on a tick", 110, 10, 20, false, nil},
+		{"on the first tick", 100, 10, 10, false, nil},
+		{"on the last tick", 130, 10, 50, false, nil},
+		{"before the first tick", 95, MaxTolerance, 0, false, ErrNotFound},
+		{"after the last tick", 135, MaxTolerance, 0, false, ErrNotFound},
+		{"earlier tick out of tolerance", 125, 10, 0, false, ErrNotFound},
+		{"later tick out of tolerance", 115, 10, 0, false, ErrNotFound},
+	}
+
+	for _, v := range cases {
+		match, err := FindInterpolated(db, brtiTable(t), v.ts, v.tolerance)
+		if err != v.err {
+			t.Errorf("%v: FindInterpolated() error = %v, want %v", v.name, err, v.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if match.Timestamp != v.ts || !almostEqual(match.Price, v.price) || match.Interpolated != v.interpolated {
+			t.Errorf("%v: FindInterpolated() = %+v, want %v interpolated %v", v.name, match, v.price, v.interpolated)
+		}
+		if v.interpolated && (match.Before == nil || match.After == nil || match.Before.Timestamp >= v.ts || match.After.Timestamp <= v.ts) {
+			t.Errorf("%v: FindInterpolated() = %+v, want the ticks around %v", v.name, match, v.ts)
+		}
+	}
+
+	if _, err := FindInterpolated(db, brtiTable(t), 120, MaxTolerance+1); err == nil || err == ErrNotFound {
+		t.Errorf("FindInterpolated() = %v, want a tolerance error", err)
+	}
+}
@@ -0,0 +1,75 @@
+package tick
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MaxPageSize is the hard cap of ticks returned by a single page
+const MaxPageSize = 1000
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type Page struct {
+	Ticks []Tick `json:"ticks"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// FindPage reads a page of ticks between tsStart and tsEnd, the cursor is the next_cursor of the previous page or empty for the first page
+func FindPage(db *sql.DB, table Table, tsStart int64, tsEnd int64, cursor string, limit int, desc bool) (Page, error) {
+	result := Page{Ticks: []Tick{}}
+
+	if limit < 1 || limit > MaxPageSize {
+		log.WithField("limit", limit).Warn("query limit out of range")
+		return result, errors.New("query limit out of range")
+	}
+
+	order := "ASC"
+	if desc {
+		order = "DESC"
+	}
+
+	// log_time is the primary key, so the last timestamp of a page is enough to continue
+	if cursor != "" {
+		after, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil {
+			return result, ErrInvalidCursor
+		}
+
+		if desc {
+			tsEnd = after - 1
+		} else {
+			tsStart = after + 1
+		}
+	}
+
+	rows, err := db.Query(fmt.Sprintf("SELECT `log_time`,`log_price` FROM `%v` WHERE `log_time` BETWEEN ? AND ? ORDER BY `log_time` %v LIMIT ?", table.Name, order), tsStart, tsEnd, limit)
+	if err != nil {
+		log.WithFields(log.Fields{"source": table.Source, "product": table.Product}).WithError(err).Error("query page error")
+		return result, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var t Tick
+
+		err = rows.Scan(&t.Timestamp, &t.Price)
+		if err != nil {
+			log.WithFields(log.Fields{"source": table.Source, "product": table.Product}).WithError(err).Error("read page error")
+			return result, err
+		}
+
+		result.Ticks = append(result.Ticks, t)
+	}
+
+	if len(result.Ticks) == limit {
+		result.NextCursor = strconv.FormatInt(result.Ticks[limit-1].Timestamp, 10)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,109 @@
+package tick
+
+import (
+	"testing"
+)
+
+// readPages walks through the pages following the cursors and returns the timestamps read and the pages count
+func readPages(t *testing.T, find func(cursor string) (Page, error)) ([]int64, int) {
+	var result []int64
+	pages := 0
+	cursor := ""
+
+	for {
+		page, err := find(cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+
+		for _, v := range page.Ticks {
+			result = append(result, v.Timestamp)
+		}
+
+		if page.NextCursor == "" {
+			return result, pages
+		}
+		if pages > 100 {
+			t.Fatal("the cursors do not end")
+		}
+		cursor = page.NextCursor
+	}
+}
+
+func TestFindPageCursors(t *testing.T) {
+	db := openTestDB(t)
+
+	// consecutive timestamps so a cursor off by one second skips or repeats a tick
+	saveTicks(t, db,
+		Tick{Timestamp: 1, Price: 1},
+		Tick{Timestamp: 2, Price: 2},
+		Tick{Timestamp: 3, Price: 3},
+		Tick{Timestamp: 4, Price: 4},
+		Tick{Timestamp: 5, Price: 5},
+		Tick{Timestamp: 7, Price: 7})
+
+	// the timestamps are unique, a cursor on the timestamp cannot split equal timestamps over two pages
+	if _, err := db.Exec("INSERT INTO `brti_logs`(`log_time`,`log_price`) VALUES(?,?)", 3, 30); err == nil {
+		t.Fatal("a second tick of the same timestamp should be rejected")
+	}
+
+	cases := []struct {
+		name string
+		start int64
+		end int64
+		limit int
+		desc bool
+		want []int64
+		pages int
+	}{
+		{"ascending", 0, 10, 2, false, []int64{1, 2, 3, 4, 5, 7}, 4},
+		{"descending", 0, 10, 2, true, []int64{7, 5, 4, 3, 2, 1}, 4},
+		{"last page not full", 0, 10, 4, false, []int64{1, 2, 3, 4, 5, 7}, 2},
+		{"single page", 0, 10, 10, false, []int64{1, 2, 3, 4, 5, 7}, 1},
+		{"range", 2, 5, 1, false, []int64{2, 3, 4, 5}, 5},
+		{"range descending", 2, 5, 3, true, []int64{5, 4, 3, 2}, 2},
+		{"empty range", 8, 10, 2, false, nil, 1},
+	}
+
+	for _, v := range cases {
+		got, pages := readPages(t, func(cursor string) (Page, error) {
+			return FindPage(db, brtiTable(t), v.start, v.end, cursor, v.limit, v.desc)
+		})
+
+		if len(got) != len(v.want) || pages != v.pages {
+			t.Errorf("%v: read %v in %v pages, want %v in %v pages", v.name, got, pages, v.want, v.pages)
+			continue
+		}
+		for i := range got {
+			if got[i] != v.want[i] {
+				t.Errorf("%v: read %v, want %v", v.name, got, v.want)
+				break
+			}
+		}
+	}
+}
+
+func TestFindPageInvalid(t *testing.T) {
+	db := openTestDB(t)
+
+	cases := []struct {
+		name string
+		cursor string
+		limit int
+	}{
+		{"cursor", "abc", 10},
+		{"no limit", "", 0},
+		{"limit over the max", "", MaxPageSize + 1},
+	}
+
+	for _, v := range cases {
+		if _, err := FindPage(db, brtiTable(t), 0, 10, v.cursor, v.limit, false); err == nil {
+			t.Errorf("%v: FindPage() should fail", v.name)
+		}
+	}
+
+	if _, err := FindPage(db, brtiTable(t), 0, 10, "abc", 10, false); err != ErrInvalidCursor {
+		t.Errorf("FindPage() error = %v, want %v", err, ErrInvalidCursor)
+	}
+}
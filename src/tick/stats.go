@@ -0,0 +1,55 @@
+package tick
+
+import (
+	"database/sql"
+	"math"
+)
+
+// Stats summarizes the ticks in a time range, all price fields are zero when Count is zero
+type Stats struct {
+	Start int64 `json:"start"`
+	End int64 `json:"end"`
+	Count int64 `json:"count"`
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+	First float64 `json:"first"`
+	Last float64 `json:"last"`
+	Mean float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+}
+
+// FindStats computes the range statistics in a single pass, the standard deviation is the population one
+func FindStats(db *sql.DB, table Table, tsStart int64, tsEnd int64) (Stats, error) {
+	result := Stats{Start: tsStart, End: tsEnd}
+
+	// Welford's algorithm, summing squares of prices loses too much precision
+	var m2 float64
+
+	err := Each(db, table, tsStart, tsEnd, func(t Tick) error {
+		if result.Count == 0 {
+			result.Min = t.Price
+			result.Max = t.Price
+			result.First = t.Price
+		}
+
+		result.Count++
+		result.Min = math.Min(result.Min, t.Price)
+		result.Max = math.Max(result.Max, t.Price)
+		result.Last = t.Price
+
+		delta := t.Price - result.Mean
+		result.Mean += delta / float64(result.Count)
+		m2 += delta * (t.Price - result.Mean)
+
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	if result.Count > 0 {
+		result.StdDev = math.Sqrt(m2 / float64(result.Count))
+	}
+
+	return result, nil
+}
@@ -0,0 +1,49 @@
+package tick
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFindStats(t *testing.T) {
+	db := openTestDB(t)
+	saveTicks(t, db,
+		Tick{Timestamp: 10, Price: 20},
+		Tick{Timestamp: 20, Price: 40},
+		Tick{Timestamp: 30, Price: 10},
+		Tick{Timestamp: 40, Price: 30},
+		// large prices close to each other, the squares of the prices would lose their spread
+		Tick{Timestamp: 100, Price: 1e9 + 1},
+		Tick{Timestamp: 110, Price: 1e9 + 2},
+		Tick{Timestamp: 120, Price: 1e9 + 3})
+
+	cases := []struct {
+		name string
+		start int64
+		end int64
+		want Stats
+	}{
+		{"range", 10, 40, Stats{Count: 4, Min: 10, Max: 40, First: 20, Last: 30, Mean: 25, StdDev: math.Sqrt(125)}},
+		{"bounds included", 20, 30, Stats{Count: 2, Min: 10, Max: 40, First: 40, Last: 10, Mean: 25, StdDev: 15}},
+		{"single tick", 15, 25, Stats{Count: 1, Min: 40, Max: 40, First: 40, Last: 40, Mean: 40}},
+		{"large prices", 100, 120, Stats{Count: 3, Min: 1e9 + 1, Max: 1e9 + 3, First: 1e9 + 1, Last: 1e9 + 3, Mean: 1e9 + 2, StdDev: math.Sqrt(2.0 / 3)}},
+		{"no tick", 50, 90, Stats{}},
+	}
+
+	for _, v := range cases {
+		stats, err := FindStats(db, brtiTable(t), v.start, v.end)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		v.want.Start = v.start
+		v.want.End = v.end
+		if !almostEqual(stats.StdDev, v.want.StdDev) {
+			t.Errorf("%v: FindStats() stddev = %v, want %v", v.name, stats.StdDev, v.want.StdDev)
+		}
+		stats.StdDev = v.want.StdDev
+		if stats != v.want {
+			t.Errorf("%v: FindStats() = %+v, want %+v", v.name, stats, v.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package tick
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	SourceBrti = "brti"
+	SourceGdax = "gdax"
+	SourceBitstamp = "bitstamp"
+)
+
+const ProductBtcUsd = "btcusd"
+
+// Table describes a raw tick table, every tick table has a `log_time` and a `log_price` column
+type Table struct {
+	Source string `json:"source"`
+	Product string `json:"product"`
+	Name string `json:"-"`
+}
+
+type Tick struct {
+	Timestamp int64 `json:"timestamp"`
+	Price float64 `json:"price"`
+}
+
+var Tables = []Table{
+	{SourceBrti, ProductBtcUsd, "brti_logs"},
+	{SourceGdax, ProductBtcUsd, "gdax_btcusd_logs"},
+	{SourceBitstamp, ProductBtcUsd, "bitstamp_btcusd_logs"},
+}
+
+func FindTable(source string, product string) (Table, error) {
+	for _, v := range Tables {
+		if v.Source == source && v.Product == product {
+			return v, nil
+		}
+	}
+
+	return Table{}, errors.New(fmt.Sprintf("unknown source: %v/%v", source, product))
+}
+
+// Querier reads the ticks, a *sql.DB or a *sql.Tx to read within a transaction
+type Querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Each walks through the ticks between tsStart and tsEnd in ascending order without loading them all into memory
+func Each(db Querier, table Table, tsStart int64, tsEnd int64, fn func(Tick) error) error {
+	rows, err := db.Query(fmt.Sprintf("SELECT `log_time`,`log_price` FROM `%v` WHERE `log_time` BETWEEN ? AND ? ORDER BY `log_time` ASC", table.Name), tsStart, tsEnd)
+	if err != nil {
+		log.WithFields(log.Fields{"source": table.Source, "product": table.Product}).WithError(err).Error("query ticks error")
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var t Tick
+
+		err = rows.Scan(&t.Timestamp, &t.Price)
+		if err != nil {
+			log.WithFields(log.Fields{"source": table.Source, "product": table.Product}).WithError(err).Error("read ticks error")
+			return err
+		}
+
+		err = fn(t)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Inserted sums up the ticks saved after a rowid, Rowid is the last one saved, the other fields are zero when Count is zero
+type Inserted struct {
+	Rowid int64
+	Count int64
+	Earliest int64
+	Latest int64
+}
+
+// FindInserted finds the ticks saved after rowid whatever their timestamps, the rowid follows the insertion order
+// so the ticks saved late with older timestamps are found too
+func FindInserted(db Querier, table Table, rowid int64) (Inserted, error) {
+	result := Inserted{Rowid: rowid}
+
+	rows, err := db.Query(fmt.Sprintf("SELECT MAX(`rowid`),COUNT(*),MIN(`log_time`),MAX(`log_time`) FROM `%v` WHERE `rowid` > ?", table.Name), rowid)
+	if err != nil {
+		log.WithFields(log.Fields{"source": table.Source, "product": table.Product}).WithError(err).Error("query inserted ticks error")
+		return result, err
+	}
+
+	defer rows.Close()
+
+	if rows.Next() {
+		var last sql.NullInt64
+		var earliest sql.NullInt64
+		var latest sql.NullInt64
+
+		err = rows.Scan(&last, &result.Count, &earliest, &latest)
+		if err != nil {
+			log.WithFields(log.Fields{"source": table.Source, "product": table.Product}).WithError(err).Error("read inserted ticks error")
+			return result, err
+		}
+
+		if result.Count > 0 {
+			result.Rowid = last.Int64
+			result.Earliest = earliest.Int64
+			result.Latest = latest.Int64
+		}
+	}
+
+	return result, rows.Err()
+}
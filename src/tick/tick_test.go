@@ -0,0 +1,43 @@
+package tick
+
+import (
+	"brti"
+	"database/sql"
+	"math"
+	"path/filepath"
+	"testing"
+	"util"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	db, err := util.OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	brti.InitDb(db)
+
+	return db
+}
+
+func saveTicks(t *testing.T, db *sql.DB, ticks ...Tick) {
+	for _, v := range ticks {
+		_, err := db.Exec("INSERT INTO `brti_logs`(`log_time`,`log_price`) VALUES(?,?)", v.Timestamp, v.Price)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func brtiTable(t *testing.T) Table {
+	table, err := FindTable(SourceBrti, ProductBtcUsd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+func almostEqual(a float64, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
@@ -2,12 +2,151 @@ package util
 
 import (
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
-	"log"
+	log "github.com/sirupsen/logrus"
+	"github.com/mattn/go-sqlite3"
 )
 
+// driverName is the driver used by OpenDB, AttachDBs replaces it by one attaching the extra db files
+var driverName = "sqlite3"
+
+// OpenDB opens the sqlite db by its path or its DSN like file:/data/brti.db?_busy_timeout=5000
 func OpenDB(dbPath string) (*sql.DB, error) {
-	return sql.Open("sqlite3", dbPath)
+	return sql.Open(driverName, dbPath)
+}
+
+// AttachDBs makes every connection opened by OpenDB attach the db files by their schema names,
+// the tables of the attached files are found by their names as long as the main db has no table of the same name, see ShadowedTables.
+// It should be called once before any db is opened
+func AttachDBs(files map[string]string) {
+	if len(files) == 0 {
+		return
+	}
+
+	sql.Register("sqlite3_attached", &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			for name, file := range files {
+				_, err := conn.Exec(fmt.Sprintf("ATTACH DATABASE ? AS `%v`", name), []driver.Value{file})
+				if err != nil {
+					log.WithFields(log.Fields{"schema": name, "file": file}).WithError(err).Error("attach db error")
+					return err
+				}
+			}
+
+			return nil
+		},
+	})
+
+	driverName = "sqlite3_attached"
+}
+
+// ShadowedTables lists the tables of the attached db files hidden by a table of the same name in the main db,
+// like a tick table left in the main db after its source moved to its own file. The db should be opened after AttachDBs
+func ShadowedTables(db *sql.DB, files map[string]string) ([]string, error) {
+	var result []string
+
+	for name := range files {
+		rows, err := db.Query(fmt.Sprintf("SELECT `name` FROM `%v`.sqlite_master WHERE `type`='table' AND `name` IN (SELECT `name` FROM main.sqlite_master WHERE `type`='table')", name))
+		if err != nil {
+			log.WithField("schema", name).WithError(err).Error("query shadowed tables error")
+			return nil, err
+		}
+
+		for rows.Next() {
+			var table string
+			err = rows.Scan(&table)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+
+			result = append(result, fmt.Sprintf("%v.%v", name, table))
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// Row is a row saved by Sql with Args, the rows of a table share the same Sql so it is prepared once in a transaction.
+// Replace tells the stored row of the same key is overwritten instead of kept
+type Row struct {
+	Table string
+	Sql string
+	Args []interface{}
+	Replace bool
+}
+
+// SaveRows saves the rows in a transaction, either all of them are saved or none on error.
+// The result tells for each row whether it is newly saved or replaced, false when the stored one is kept
+func SaveRows(db *sql.DB, rows []Row) ([]bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.WithError(err).Error("begin tx error")
+		return nil, err
+	}
+
+	stmts := make(map[string]*sql.Stmt)
+	defer func() {
+		for _, stmt := range stmts {
+			stmt.Close()
+		}
+	}()
+
+	result := make([]bool, len(rows))
+	for i, row := range rows {
+		stmt, ok := stmts[row.Sql]
+		if !ok {
+			stmt, err = tx.Prepare(row.Sql)
+			if err != nil {
+				log.WithField("table", row.Table).WithError(err).Error("prepare stmt error")
+				tx.Rollback()
+				return nil, err
+			}
+			stmts[row.Sql] = stmt
+		}
+
+		res, err := stmt.Exec(row.Args...)
+		if err != nil {
+			// the busy db is expected under load, the caller decides whether to retry
+			if IsBusy(err) {
+				log.WithField("table", row.Table).WithError(err).Debug("exec save sql error")
+			} else {
+				log.WithField("table", row.Table).WithError(err).Error("exec save sql error")
+			}
+			tx.Rollback()
+			return nil, err
+		}
+
+		affectedRows, err := res.RowsAffected()
+		if err != nil {
+			log.WithField("table", row.Table).WithError(err).Error("read affected rows error")
+			tx.Rollback()
+			return nil, err
+		}
+
+		result[i] = affectedRows > 0
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.WithError(err).Error("commit tx error")
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// IsBusy tells whether the error is caused by the db locked by another connection, the write can be retried later
+func IsBusy(err error) bool {
+	e, ok := err.(sqlite3.Error)
+	return ok && (e.Code == sqlite3.ErrBusy || e.Code == sqlite3.ErrLocked)
 }
 
 func CheckAndCreateTable(db *sql.DB, tableName string, initSql string)  {
@@ -19,14 +158,52 @@ func CheckAndCreateTable(db *sql.DB, tableName string, initSql string)  {
 	defer rows.Close()
 
 	if !rows.Next() {
-		log.Printf("init table %v\n", tableName)
+		log.WithField("table", tableName).Info("init table")
 
 		err = ExecuteStmtSql(db, initSql)
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		log.Printf("init table %v success\n", tableName)
+		log.WithField("table", tableName).Info("init table success")
+	}
+}
+
+func CheckAndAddColumn(db *sql.DB, tableName string, columnName string, definition string) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(`%v`)", tableName))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	found := false
+	for rows.Next() {
+		var cid int
+		var name string
+		var columnType string
+		var notNull int
+		var defaultValue interface{}
+		var pk int
+
+		err = rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if name == columnName {
+			found = true
+		}
+	}
+	rows.Close()
+
+	if !found {
+		log.WithFields(log.Fields{"table": tableName, "column": columnName}).Info("add column")
+
+		err = ExecuteStmtSql(db, fmt.Sprintf("ALTER TABLE `%v` ADD COLUMN `%v` %v", tableName, columnName, definition))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.WithFields(log.Fields{"table": tableName, "column": columnName}).Info("add column success")
 	}
 }
 
@@ -0,0 +1,57 @@
+package util
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestShadowedTables(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "main.db")
+	gdaxPath := filepath.Join(dir, "gdax.db")
+
+	for path, tables := range map[string][]string{
+		dbPath: {"brti_logs", "gdax_btcusd_logs"},
+		gdaxPath: {"gdax_btcusd_logs"},
+	} {
+		db, err := OpenDB(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, v := range tables {
+			err = ExecuteStmtSql(db, "CREATE TABLE `"+v+"` (`log_time` BIGINT PRIMARY KEY)")
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		db.Close()
+	}
+
+	files := map[string]string{"gdax": gdaxPath}
+	AttachDBs(files)
+
+	db, err := OpenDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	shadowed, err := ShadowedTables(db, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shadowed) != 1 || shadowed[0] != "gdax.gdax_btcusd_logs" {
+		t.Errorf("ShadowedTables() = %v, want [gdax.gdax_btcusd_logs]", shadowed)
+	}
+
+	err = ExecuteStmtSql(db, "DROP TABLE main.`gdax_btcusd_logs`")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shadowed, err = ShadowedTables(db, files)
+	if err != nil || len(shadowed) != 0 {
+		t.Errorf("ShadowedTables() = %v, %v, want none once the main table is dropped", shadowed, err)
+	}
+}